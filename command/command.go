@@ -1,67 +1,485 @@
 package main
 
-import "fmt"
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
 
-// 命令角色
+// 命令角色。Execute 返回撤销这一次执行的函数, 命令本身不保存撤销需要的状态,
+// 因此同一个命令可以多次执行, 每次执行得到各自的撤销函数。不能撤销的命令返回 nil
 type Command interface {
-	Execute()
+	Execute() (UndoFunc, error)
 }
 
+// 撤销一次命令执行的效果
+type UndoFunc func() error
+
 // 接收者角色
 type Light struct {
 	Name string
+	on   bool
 }
 
 func (l *Light) TurnOn() {
+	l.on = true
 	fmt.Printf("%s light is turned on\n", l.Name)
 }
 
 func (l *Light) TurnOff() {
+	l.on = false
 	fmt.Printf("%s light is turned off\n", l.Name)
 }
 
-// 具体命令角色
+func (l *Light) IsOn() bool {
+	return l.on
+}
+
+// 记录灯当前的状态, 返回的函数把灯恢复到这个状态
+func (l *Light) snapshot() UndoFunc {
+	on := l.on
+	return func() error {
+		if on {
+			l.TurnOn()
+		} else {
+			l.TurnOff()
+		}
+		return nil
+	}
+}
+
+// 具体命令角色, 执行前记录灯的状态, 撤销时恢复
 type TurnOnCommand struct {
 	light *Light
 }
 
-func (c *TurnOnCommand) Execute() {
+func (c *TurnOnCommand) Execute() (UndoFunc, error) {
+	undo := c.light.snapshot()
 	c.light.TurnOn()
+	return undo, nil
 }
 
 type TurnOffCommand struct {
 	light *Light
 }
 
-func (c *TurnOffCommand) Execute() {
+func (c *TurnOffCommand) Execute() (UndoFunc, error) {
+	undo := c.light.snapshot()
 	c.light.TurnOff()
+	return undo, nil
+}
+
+var ErrLampBurnedOut = errors.New("projector: lamp burned out")
+
+// 接收者角色, 灯泡烧坏后无法打开
+type Projector struct {
+	Name      string
+	on        bool
+	burnedOut bool
+}
+
+func (p *Projector) TurnOn() error {
+	if p.burnedOut {
+		return fmt.Errorf("%s: %w", p.Name, ErrLampBurnedOut)
+	}
+	p.on = true
+	fmt.Printf("%s projector is turned on\n", p.Name)
+	return nil
+}
+
+func (p *Projector) TurnOff() {
+	p.on = false
+	fmt.Printf("%s projector is turned off\n", p.Name)
+}
+
+func (p *Projector) IsOn() bool {
+	return p.on
+}
+
+// 模拟灯泡损坏
+func (p *Projector) BurnOutLamp() {
+	p.burnedOut = true
 }
 
-// 调用者角色
-type RemoteControl struct {
+// 记录投影仪当前的状态, 返回的函数把投影仪恢复到这个状态
+func (p *Projector) snapshot() UndoFunc {
+	on := p.on
+	return func() error {
+		if on {
+			return p.TurnOn()
+		}
+		p.TurnOff()
+		return nil
+	}
+}
+
+type ProjectorOnCommand struct {
+	projector *Projector
+}
+
+func (c *ProjectorOnCommand) Execute() (UndoFunc, error) {
+	undo := c.projector.snapshot()
+	if err := c.projector.TurnOn(); err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
+
+type ProjectorOffCommand struct {
+	projector *Projector
+}
+
+func (c *ProjectorOffCommand) Execute() (UndoFunc, error) {
+	undo := c.projector.snapshot()
+	c.projector.TurnOff()
+	return undo, nil
+}
+
+// 宏命令, 按顺序执行一组命令。
+// 某一步失败时按相反顺序撤销已经执行的步骤, 整个宏要么全部生效, 要么都不生效
+type MacroCommand struct {
+	Name     string
 	commands []Command
 }
 
-func (r *RemoteControl) SetCommand(index int, command Command) {
-	r.commands[index] = command
+func NewMacroCommand(name string, commands ...Command) *MacroCommand {
+	return &MacroCommand{Name: name, commands: commands}
 }
 
-func (r *RemoteControl) PressButton(index int) {
-	r.commands[index].Execute()
+// 依次执行每一步, 返回的撤销函数按相反顺序调用各步的撤销函数
+func (m *MacroCommand) Execute() (UndoFunc, error) {
+	undos := make([]UndoFunc, 0, len(m.commands))
+	for i, command := range m.commands {
+		undo, err := command.Execute()
+		if err != nil {
+			err = fmt.Errorf("macro %s: step %d: %w", m.Name, i+1, err)
+			if rollbackErr := undoAll(undos); rollbackErr != nil {
+				return nil, errors.Join(err, fmt.Errorf("macro %s: rollback: %w", m.Name, rollbackErr))
+			}
+			return nil, err
+		}
+		undos = append(undos, undo)
+	}
+	return func() error {
+		if err := undoAll(undos); err != nil {
+			return fmt.Errorf("macro %s: undo: %w", m.Name, err)
+		}
+		return nil
+	}, nil
 }
 
-func main() {
-	light := &Light{"Living Room"}
-	turnOnCommand := &TurnOnCommand{light}
-	turnOffCommand := &TurnOffCommand{light}
+// 按相反顺序调用撤销函数, 某一步撤销失败时继续撤销其余的步骤, 返回所有错误。
+// 不能撤销的步骤报告 ErrNotUndoable
+func undoAll(undos []UndoFunc) error {
+	var errs []error
+	for i := len(undos) - 1; i >= 0; i-- {
+		if undos[i] == nil {
+			errs = append(errs, ErrNotUndoable)
+			continue
+		}
+		if err := undos[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	ErrNothingToUndo = errors.New("command: nothing to undo")
+	ErrNothingToRedo = errors.New("command: nothing to redo")
+)
+
+// 撤销栈中的一条记录: 执行过的命令和撤销这次执行的函数
+type executed struct {
+	command Command
+	undo    UndoFunc
+}
+
+// 命令历史, 维护撤销栈和重做栈。
+// 撤销栈超过 limit 时丢弃最早的命令, limit <= 0 表示不限制
+type CommandHistory struct {
+	undo  []executed
+	redo  []Command
+	limit int
+}
 
-	remoteControl := &RemoteControl{
-		commands: make([]Command, 2),
+func NewCommandHistory(limit int) *CommandHistory {
+	return &CommandHistory{limit: limit}
+}
+
+// 执行命令并记入历史, 新的命令会清空重做栈。执行失败的命令不记入历史
+func (h *CommandHistory) Execute(command Command) error {
+	undo, err := command.Execute()
+	if err != nil {
+		return err
+	}
+	h.push(executed{command: command, undo: undo})
+	clear(h.redo)
+	h.redo = h.redo[:0]
+	return nil
+}
+
+func (h *CommandHistory) push(e executed) {
+	h.undo = append(h.undo, e)
+	if h.limit > 0 && len(h.undo) > h.limit {
+		n := copy(h.undo, h.undo[len(h.undo)-h.limit:])
+		clear(h.undo[n:])
+		h.undo = h.undo[:n]
+	}
+}
+
+// 撤销最近执行的命令, 撤销失败或命令不能撤销时命令留在撤销栈中
+func (h *CommandHistory) Undo() error {
+	if len(h.undo) == 0 {
+		return ErrNothingToUndo
+	}
+	top := h.undo[len(h.undo)-1]
+	if top.undo == nil {
+		return ErrNotUndoable
+	}
+	if err := top.undo(); err != nil {
+		return err
 	}
+	h.undo[len(h.undo)-1] = executed{}
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, top.command)
+	return nil
+}
+
+// 重新执行最近撤销的命令, 执行失败时命令留在重做栈中
+func (h *CommandHistory) Redo() error {
+	if len(h.redo) == 0 {
+		return ErrNothingToRedo
+	}
+	command := h.redo[len(h.redo)-1]
+	undo, err := command.Execute()
+	if err != nil {
+		return err
+	}
+	h.redo[len(h.redo)-1] = nil
+	h.redo = h.redo[:len(h.redo)-1]
+	h.push(executed{command: command, undo: undo})
+	return nil
+}
+
+func (h *CommandHistory) CanUndo() bool {
+	return len(h.undo) > 0
+}
+
+func (h *CommandHistory) CanRedo() bool {
+	return len(h.redo) > 0
+}
+
+func main() {
+	light := &Light{Name: "Living Room"}
+	turnOnCommand := &TurnOnCommand{light: light}
+	turnOffCommand := &TurnOffCommand{light: light}
+
+	remoteControl := NewRemoteControl(3, NewCommandHistory(2))
 
 	remoteControl.SetCommand(0, turnOnCommand)
 	remoteControl.SetCommand(1, turnOffCommand)
 
 	remoteControl.PressButton(0) // 打开客厅灯
 	remoteControl.PressButton(1) // 关闭客厅灯
+
+	remoteControl.PressUndo() // 撤销关灯, 客厅灯恢复打开
+	remoteControl.PressRedo() // 重做关灯
+	fmt.Println(light.IsOn()) // 输出: false
+
+	// 历史最多保留 2 条, 第三次撤销时最早的命令已经被丢弃
+	remoteControl.PressButton(0)
+	remoteControl.PressUndo()
+	remoteControl.PressUndo()
+	fmt.Println(remoteControl.PressUndo()) // 输出: command: nothing to undo
+	fmt.Println(light.IsOn())              // 输出: true
+
+	// 同一个按钮的每次执行都有自己的撤销函数, 撤销三次回到最初关灯的状态
+	light.TurnOff()
+	repeated := NewRemoteControl(2, nil)
+	repeated.SetCommand(0, turnOnCommand)
+	repeated.SetCommand(1, turnOffCommand)
+	repeated.PressButton(1)
+	repeated.PressButton(0)
+	repeated.PressButton(1)
+	repeated.PressUndo()
+	repeated.PressUndo()
+	repeated.PressUndo()
+	fmt.Println(light.IsOn()) // 输出: false
+	light.TurnOn()
+
+	// 观影模式: 关掉三盏灯, 打开投影仪
+	kitchen, hallway := &Light{Name: "Kitchen"}, &Light{Name: "Hallway"}
+	kitchen.TurnOn()
+	hallway.TurnOn()
+	projector := &Projector{Name: "Home Theater"}
+	movieMode := NewMacroCommand("movie mode",
+		&TurnOffCommand{light: light},
+		&TurnOffCommand{light: kitchen},
+		&TurnOffCommand{light: hallway},
+		&ProjectorOnCommand{projector: projector},
+	)
+	remoteControl.SetCommand(2, movieMode)
+	remoteControl.PressButton(2)
+	fmt.Println(light.IsOn(), kitchen.IsOn(), hallway.IsOn(), projector.IsOn()) // 输出: false false false true
+	remoteControl.PressUndo()
+	fmt.Println(light.IsOn(), kitchen.IsOn(), hallway.IsOn(), projector.IsOn()) // 输出: true true true false
+
+	// 投影仪打不开时, 已经关掉的灯按相反顺序恢复
+	projector.BurnOutLamp()
+	err := remoteControl.PressButton(2)
+	fmt.Println(err, errors.Is(err, ErrLampBurnedOut))                          // 输出: macro movie mode: step 4: Home Theater: projector: lamp burned out true
+	fmt.Println(light.IsOn(), kitchen.IsOn(), hallway.IsOn(), projector.IsOn()) // 输出: true true true false
+
+	// 后台任务: 在两个 worker 上执行, 失败时重试, 超时的任务被取消
+	queue := NewQueue(context.Background(), QueueOptions{Workers: 2, Buffer: 4, MaxAttempts: 3, Backoff: 10 * time.Millisecond})
+	failures := 2
+	queue.Submit(context.Background(), CommandFunc(func(ctx context.Context) error {
+		if failures > 0 {
+			failures--
+			return errors.New("service unavailable")
+		}
+		return nil
+	}))
+	queue.SubmitJob(context.Background(), Job{
+		Command: CommandFunc(func(ctx context.Context) error {
+			select {
+			case <-time.After(time.Second):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}),
+		Timeout:     20 * time.Millisecond,
+		MaxAttempts: 1,
+	})
+	// panic 的命令只让自己的任务失败, worker 继续执行其他任务
+	queue.SubmitJob(context.Background(), Job{
+		Command: CommandFunc(func(ctx context.Context) error {
+			var lights map[string]*Light
+			lights["porch"].TurnOn()
+			return nil
+		}),
+		MaxAttempts: 1,
+	})
+	// 不支持取消的命令超时后仍在执行, 任务以 ErrAbandoned 结束, 不会重试
+	queue.SubmitJob(context.Background(), Job{
+		Command: NewMacroCommand("slow", CommandFunc(func(ctx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})),
+		Timeout: 10 * time.Millisecond,
+	})
+	_, err = queue.Submit(context.Background(), nil)
+	fmt.Println(err) // 输出: command: nil command
+	go queue.Close()
+	var results []Result
+	for result := range queue.Results() {
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b Result) int { return cmp.Compare(a.Job.ID, b.Job.ID) })
+	for _, result := range results {
+		fmt.Println(result.Job.ID, result.Attempts, result.Err)
+	}
+	// 输出:
+	// 1 3 <nil>
+	// 2 1 context deadline exceeded
+	// 3 1 command: panicked: runtime error: invalid memory address or nil pointer dereference
+	// 4 1 command: abandoned while still running: context deadline exceeded
+
+	// 预写日志: 命令执行前先写入文件, 重启后回放日志恢复灯的状态
+	dir, _ := os.MkdirTemp("", "journal")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "commands.log")
+	openJournal := func(receivers *Receivers) *Journal {
+		registry := NewCommandRegistry()
+		receivers.Register(registry)
+		journal, err := OpenJournal(path, registry)
+		if err != nil {
+			panic(err)
+		}
+		return journal
+	}
+
+	kitchen, hallway = &Light{Name: "Kitchen"}, &Light{Name: "Hallway"}
+	projector = &Projector{Name: "Home Theater"}
+	journal := openJournal(&Receivers{
+		Lights:     map[string]*Light{"Kitchen": kitchen, "Hallway": hallway},
+		Projectors: map[string]*Projector{"Home Theater": projector},
+	})
+	journal.Execute(&TurnOnCommand{light: kitchen})
+	journal.Execute(&TurnOnCommand{light: hallway})
+	journal.Execute(NewMacroCommand("movie mode", &TurnOffCommand{light: hallway}, &ProjectorOnCommand{projector: projector}))
+	projector.BurnOutLamp()
+	journal.Execute(&ProjectorOnCommand{projector: projector}) // 执行失败, 回放时跳过
+	// 通过历史撤销的命令同样记入日志, 回放时跳过
+	history := NewCommandHistory(0)
+	history.Execute(journal.Journaled(&TurnOffCommand{light: kitchen}))
+	history.Undo()
+	journal.Close()
+
+	// 重启: 新的接收者都处于初始状态
+	kitchen, hallway = &Light{Name: "Kitchen"}, &Light{Name: "Hallway"}
+	projector = &Projector{Name: "Home Theater"}
+	journal = openJournal(&Receivers{
+		Lights:     map[string]*Light{"Kitchen": kitchen, "Hallway": hallway},
+		Projectors: map[string]*Projector{"Home Theater": projector},
+	})
+	defer journal.Close()
+	n, err := journal.Replay()
+	fmt.Println(n, err)                                           // 输出: 3 <nil>
+	fmt.Println(kitchen.IsOn(), hallway.IsOn(), projector.IsOn()) // 输出: true false true
+
+	// 按键绑定: 插槽可以命名, 按需增加, 可以从配置文件加载
+	remoteControl = NewRemoteControl(2, NewCommandHistory(0))
+	fmt.Println(remoteControl.PressButton(0)) // 输出: command: button is not bound: 0
+	fmt.Println(remoteControl.Press("movie")) // 输出: command: button is not bound: "movie"
+	err = remoteControl.LoadBindings(strings.NewReader(`
+		# 客厅
+		0     = living-room.on
+		1     = living-room.off
+		4     = kitchen.on   # 插槽 2, 3 保持未绑定
+		movie = movie-mode
+	`), map[string]Command{
+		"living-room.on":  &TurnOnCommand{light: light},
+		"living-room.off": &TurnOffCommand{light: light},
+		"kitchen.on":      &TurnOnCommand{light: kitchen},
+		"movie-mode":      NewMacroCommand("movie mode", &TurnOffCommand{light: light}, &TurnOffCommand{light: kitchen}),
+	})
+	fmt.Println(err) // 输出: <nil>
+	remoteControl.Unbind("movie")
+	for i, slot := range remoteControl.Bindings() {
+		fmt.Printf("%d %q %T\n", i, slot.Name, slot.Command)
+	}
+	// 输出:
+	// 0 "" *main.TurnOnCommand
+	// 1 "" *main.TurnOffCommand
+	// 2 "" main.NoCommand
+	// 3 "" main.NoCommand
+	// 4 "" *main.TurnOnCommand
+	// 5 "movie" main.NoCommand
+
+	// 位置插槽和命名插槽分开保存, 绑定位置 5 不会改动命名插槽 movie
+	remoteControl.SetCommand(5, &TurnOffCommand{light: kitchen})
+	fmt.Println(remoteControl.Press("movie"), len(remoteControl.Bindings())) // 输出: command: button is not bound: "movie" 7
+
+	err = remoteControl.LoadBindings(strings.NewReader("0 = living-room.dim"), nil)
+	fmt.Println(err) // 输出: command: bindings line 1: unknown command "living-room.dim"
+	err = remoteControl.LoadBindings(strings.NewReader("300000000 = living-room.on"), nil)
+	fmt.Println(err) // 输出: command: bindings line 1: command: invalid slot: 300000000
+
+	// 没有传入历史时使用默认的历史, 按钮照常可以撤销
+	simple := NewRemoteControl(1, nil)
+	simple.SetCommand(0, &TurnOffCommand{light: light})
+	fmt.Println(simple.PressButton(0), simple.PressUndo(), light.IsOn())
+	// 输出:
+	// Living Room light is turned off
+	// Living Room light is turned on
+	// <nil> <nil> true
 }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 可以写入日志的命令, 参数由 json.Marshal(command) 编码
+type SerializableCommand interface {
+	Command
+	CommandType() string
+}
+
+// 把日志中的参数解码为命令
+type CommandDecoder func(params json.RawMessage) (Command, error)
+
+// 命令类型注册表, 日志按类型名查找解码器
+type CommandRegistry struct {
+	decoders map[string]CommandDecoder
+}
+
+// 创建注册表, 宏命令已经注册
+func NewCommandRegistry() *CommandRegistry {
+	r := &CommandRegistry{decoders: make(map[string]CommandDecoder)}
+	r.decoders[macroType] = r.decodeMacro
+	return r
+}
+
+func (r *CommandRegistry) Register(typ string, decoder CommandDecoder) error {
+	if typ == "" {
+		return errors.New("command: empty command type")
+	}
+	if _, ok := r.decoders[typ]; ok {
+		return fmt.Errorf("command: command type %q already registered", typ)
+	}
+	r.decoders[typ] = decoder
+	return nil
+}
+
+// 编码后的命令
+type encodedCommand struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (r *CommandRegistry) encode(command Command) (encodedCommand, error) {
+	if macro, ok := command.(*MacroCommand); ok {
+		return r.encodeMacro(macro)
+	}
+	c, ok := command.(SerializableCommand)
+	if !ok {
+		return encodedCommand{}, fmt.Errorf("command: %T is not serializable", command)
+	}
+	if _, ok := r.decoders[c.CommandType()]; !ok {
+		return encodedCommand{}, fmt.Errorf("command: command type %q not registered", c.CommandType())
+	}
+	params, err := json.Marshal(c)
+	if err != nil {
+		return encodedCommand{}, fmt.Errorf("command: encode %s: %w", c.CommandType(), err)
+	}
+	return encodedCommand{Type: c.CommandType(), Params: params}, nil
+}
+
+func (r *CommandRegistry) decode(e encodedCommand) (Command, error) {
+	decoder, ok := r.decoders[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("command: unknown command type %q", e.Type)
+	}
+	command, err := decoder(e.Params)
+	if err != nil {
+		return nil, fmt.Errorf("command: decode %s: %w", e.Type, err)
+	}
+	return command, nil
+}
+
+const macroType = "macro"
+
+type macroParams struct {
+	Name     string           `json:"name"`
+	Commands []encodedCommand `json:"commands"`
+}
+
+func (r *CommandRegistry) encodeMacro(m *MacroCommand) (encodedCommand, error) {
+	params := macroParams{Name: m.Name, Commands: make([]encodedCommand, len(m.commands))}
+	for i, command := range m.commands {
+		e, err := r.encode(command)
+		if err != nil {
+			return encodedCommand{}, err
+		}
+		params.Commands[i] = e
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return encodedCommand{}, err
+	}
+	return encodedCommand{Type: macroType, Params: data}, nil
+}
+
+func (r *CommandRegistry) decodeMacro(data json.RawMessage) (Command, error) {
+	var params macroParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	commands := make([]Command, len(params.Commands))
+	for i, e := range params.Commands {
+		command, err := r.decode(e)
+		if err != nil {
+			return nil, err
+		}
+		commands[i] = command
+	}
+	return NewMacroCommand(params.Name, commands...), nil
+}
+
+// 日志中的命令通过名字引用接收者, 回放时在这里查找
+type Receivers struct {
+	Lights     map[string]*Light
+	Projectors map[string]*Projector
+}
+
+type lightParams struct {
+	Light string `json:"light"`
+}
+
+type projectorParams struct {
+	Projector string `json:"projector"`
+}
+
+func (c *TurnOnCommand) CommandType() string  { return "light.on" }
+func (c *TurnOffCommand) CommandType() string { return "light.off" }
+
+func (c *TurnOnCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lightParams{Light: c.light.Name})
+}
+
+func (c *TurnOffCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lightParams{Light: c.light.Name})
+}
+
+func (c *ProjectorOnCommand) CommandType() string  { return "projector.on" }
+func (c *ProjectorOffCommand) CommandType() string { return "projector.off" }
+
+func (c *ProjectorOnCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(projectorParams{Projector: c.projector.Name})
+}
+
+func (c *ProjectorOffCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(projectorParams{Projector: c.projector.Name})
+}
+
+// 注册灯和投影仪命令的解码器
+func (rs *Receivers) Register(r *CommandRegistry) error {
+	return errors.Join(
+		r.Register("light.on", rs.lightCommand(func(l *Light) Command { return &TurnOnCommand{light: l} })),
+		r.Register("light.off", rs.lightCommand(func(l *Light) Command { return &TurnOffCommand{light: l} })),
+		r.Register("projector.on", rs.projectorCommand(func(p *Projector) Command { return &ProjectorOnCommand{projector: p} })),
+		r.Register("projector.off", rs.projectorCommand(func(p *Projector) Command { return &ProjectorOffCommand{projector: p} })),
+	)
+}
+
+func (rs *Receivers) lightCommand(build func(*Light) Command) CommandDecoder {
+	return func(data json.RawMessage) (Command, error) {
+		var params lightParams
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, err
+		}
+		light, ok := rs.Lights[params.Light]
+		if !ok {
+			return nil, fmt.Errorf("unknown light %q", params.Light)
+		}
+		return build(light), nil
+	}
+}
+
+func (rs *Receivers) projectorCommand(build func(*Projector) Command) CommandDecoder {
+	return func(data json.RawMessage) (Command, error) {
+		var params projectorParams
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, err
+		}
+		projector, ok := rs.Projectors[params.Projector]
+		if !ok {
+			return nil, fmt.Errorf("unknown projector %q", params.Projector)
+		}
+		return build(projector), nil
+	}
+}
+
+// 日志记录, 每行一条。命令执行失败时追加一条 aborted 记录, 撤销命令时追加一条 undone 记录,
+// 撤销失败时再追加一条同时标记 undone 和 aborted 的记录, 表示命令仍然生效。回放时跳过不生效的命令
+type journalEntry struct {
+	Seq     uint64          `json:"seq"`
+	Type    string          `json:"type,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Aborted bool            `json:"aborted,omitempty"`
+	Undone  bool            `json:"undone,omitempty"`
+}
+
+// 预写日志: 命令在执行前先追加到文件并落盘, 重启后回放日志重建接收者的状态。
+// 日志不在内存中保留记录, 回放时从文件中逐行读取
+type Journal struct {
+	file     *os.File
+	registry *CommandRegistry
+	seq      uint64
+	size     int64 // 文件中完整记录的长度, 新记录从这里开始写
+}
+
+// 打开或创建日志文件。
+// 崩溃可能在最后一行写到一半时发生, 这样的不完整记录会被截掉; 其他位置的损坏记录返回错误
+func OpenJournal(path string, registry *CommandRegistry) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{file: file, registry: registry}
+	if err := j.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// 检查记录并找到最大的序号, 截掉写到一半的最后一行
+func (j *Journal) load() error {
+	reader := bufio.NewReader(j.file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// 没有换行符的最后一行是写到一半的记录
+			if len(data) > 0 {
+				if err := j.file.Truncate(j.size); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+			return fmt.Errorf("command: journal line %d: %w", line, err)
+		}
+		j.size += int64(len(data))
+		j.seq = max(j.seq, entry.Seq)
+	}
+	_, err := j.file.Seek(j.size, io.SeekStart)
+	return err
+}
+
+// 从头逐条读取日志中的记录, 不移动写入位置
+func (j *Journal) scan(fn func(entry journalEntry) error) error {
+	reader := bufio.NewReader(io.NewSectionReader(j.file, 0, j.size))
+	for {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// 按顺序重新执行日志中生效的命令, 返回执行的命令数。
+// 应该在打开日志之后、执行新命令之前调用, 回放的命令不会再次写入日志。
+// 先读一遍日志找出执行失败和被撤销的命令, 再读一遍执行其余的命令, 内存中只保存被跳过的序号
+func (j *Journal) Replay() (int, error) {
+	skipped := make(map[uint64]bool)
+	err := j.scan(func(entry journalEntry) error {
+		switch {
+		case entry.Undone && entry.Aborted:
+			delete(skipped, entry.Seq)
+		case entry.Undone || entry.Aborted:
+			skipped[entry.Seq] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("command: replay: %w", err)
+	}
+	n := 0
+	err = j.scan(func(entry journalEntry) error {
+		if entry.Type == "" || skipped[entry.Seq] {
+			return nil
+		}
+		command, err := j.registry.decode(encodedCommand{Type: entry.Type, Params: entry.Params})
+		if err != nil {
+			return fmt.Errorf("command: replay #%d: %w", entry.Seq, err)
+		}
+		if _, err := command.Execute(); err != nil {
+			return fmt.Errorf("command: replay #%d: %w", entry.Seq, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// 先把命令写入日志再执行。执行失败时追加 aborted 记录并返回执行的错误。
+// 返回的撤销函数先追加 undone 记录再撤销, 和 CommandHistory 一样必须按执行的相反顺序调用,
+// 否则回放跳过被撤销的命令得到的状态和撤销后的状态不一致
+func (j *Journal) Execute(command Command) (UndoFunc, error) {
+	e, err := j.registry.encode(command)
+	if err != nil {
+		return nil, err
+	}
+	j.seq++
+	seq := j.seq
+	if err := j.append(journalEntry{Seq: seq, Type: e.Type, Params: e.Params}); err != nil {
+		return nil, err
+	}
+	undo, err := command.Execute()
+	if err != nil {
+		if abortErr := j.append(journalEntry{Seq: seq, Aborted: true}); abortErr != nil {
+			return nil, errors.Join(err, abortErr)
+		}
+		return nil, err
+	}
+	if undo == nil {
+		return nil, nil
+	}
+	return func() error {
+		if err := j.append(journalEntry{Seq: seq, Undone: true}); err != nil {
+			return err
+		}
+		if err := undo(); err != nil {
+			if abortErr := j.append(journalEntry{Seq: seq, Undone: true, Aborted: true}); abortErr != nil {
+				return errors.Join(err, abortErr)
+			}
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// 把命令包装为先写日志再执行的命令, 可以交给 CommandHistory 或 RemoteControl, 撤销和重做同样记入日志
+func (j *Journal) Journaled(command Command) Command {
+	return &journaled{journal: j, command: command}
+}
+
+type journaled struct {
+	journal *Journal
+	command Command
+}
+
+func (c *journaled) Execute() (UndoFunc, error) {
+	return c.journal.Execute(c.command)
+}
+
+func (j *Journal) append(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := j.file.Write(data); err != nil {
+		return fmt.Errorf("command: write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("command: sync journal: %w", err)
+	}
+	j.size += int64(len(data))
+	return nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
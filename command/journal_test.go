@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// 通过历史执行、撤销和重做的命令, 重启后回放得到同样的状态
+func TestJournalReplayFollowsUndo(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		steps  string // e: 执行下一个命令, u: 撤销, r: 重做
+		on     bool
+		replay int
+	}{
+		{"executed", "ee", false, 2},
+		{"undone", "eeu", true, 1},
+		{"undone twice", "eeuu", false, 0},
+		{"redone", "eeur", false, 2},
+		{"undone then new command", "eue", false, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "commands.log")
+			open := func(light *Light) *Journal {
+				registry := NewCommandRegistry()
+				(&Receivers{Lights: map[string]*Light{"Desk": light}}).Register(registry)
+				journal, err := OpenJournal(path, registry)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return journal
+			}
+
+			light := &Light{Name: "Desk"}
+			journal := open(light)
+			commands := []Command{&TurnOnCommand{light: light}, &TurnOffCommand{light: light}}
+			history := NewCommandHistory(0)
+			next := 0
+			for _, step := range test.steps {
+				var err error
+				switch step {
+				case 'e':
+					err = history.Execute(journal.Journaled(commands[next%len(commands)]))
+					next++
+				case 'u':
+					err = history.Undo()
+				case 'r':
+					err = history.Redo()
+				}
+				if err != nil {
+					t.Fatalf("step %c: %v", step, err)
+				}
+			}
+			if light.IsOn() != test.on {
+				t.Fatalf("before restart: on = %v, want %v", light.IsOn(), test.on)
+			}
+			journal.Close()
+
+			restarted := &Light{Name: "Desk"}
+			journal = open(restarted)
+			defer journal.Close()
+			n, err := journal.Replay()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != test.replay || restarted.IsOn() != test.on {
+				t.Errorf("replay: %d commands, on = %v; want %d, %v", n, restarted.IsOn(), test.replay, test.on)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueClosed = errors.New("command: queue closed")
+	ErrNotUndoable = errors.New("command: not undoable")
+	ErrNilCommand  = errors.New("command: nil command")
+	ErrPanicked    = errors.New("command: panicked")
+	// 命令超时或被取消时还在执行, 队列不再等待它。这样的任务不会重试, 以免和仍在执行的那一次同时修改接收者
+	ErrAbandoned = errors.New("command: abandoned while still running")
+)
+
+// 支持取消的命令, 队列优先调用 ExecuteContext, 超时后可以重试。
+// 其他命令在超时或取消后不会被打断, 队列不再等待它的结果, 以 ErrAbandoned 结束任务且不重试;
+// 被放弃的命令仍在后台执行, 不占用 worker, 因此同时执行的命令可能多于 Workers
+type ContextCommand interface {
+	Command
+	ExecuteContext(ctx context.Context) error
+}
+
+// 把函数适配为命令, 适合不需要撤销的后台任务
+type CommandFunc func(ctx context.Context) error
+
+// 执行函数, 没有撤销函数
+func (f CommandFunc) Execute() (UndoFunc, error) {
+	return nil, f(context.Background())
+}
+
+func (f CommandFunc) ExecuteContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+// 提交到队列的任务, 零值字段使用队列的默认设置
+type Job struct {
+	ID          uint64 // 由队列分配
+	Command     Command
+	Timeout     time.Duration // 单次执行的超时
+	MaxAttempts int           // 最多执行的次数, 包括第一次
+}
+
+// 任务的执行结果
+type Result struct {
+	Job      Job
+	Attempts int
+	Err      error
+}
+
+type QueueOptions struct {
+	Workers     int           // 并发执行的任务数, 默认为 1
+	Buffer      int           // 等待执行的任务数, 超过时 Submit 阻塞
+	Timeout     time.Duration // 单次执行的默认超时, 0 表示不限制
+	MaxAttempts int           // 默认最多执行的次数, 默认为 1
+	Backoff     time.Duration // 第一次重试前等待的时间, 之后每次加倍, 默认为 100ms
+	MaxBackoff  time.Duration // 重试等待时间的上限, 0 表示不限制(加倍到 time.Duration 的最大值为止)
+}
+
+// 命令队列, 在固定数量的 worker 上异步执行命令, 失败时按指数退避重试。
+// 执行结果发送到 Results, 调用者必须持续读取, 否则 worker 会阻塞
+type Queue struct {
+	ctx     context.Context
+	options QueueOptions
+	jobs    chan Job
+	results chan Result
+	workers sync.WaitGroup
+
+	nextID atomic.Uint64
+
+	// Close 先关闭 done 让等待中的提交返回, 等 senders 归零后再关闭 jobs, 因此关闭之后不会再有发送
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{}
+	senders sync.WaitGroup
+}
+
+// 创建队列并启动 worker, ctx 取消后正在执行的任务被取消, 等待中的任务直接以 ctx 的错误结束
+func NewQueue(ctx context.Context, options QueueOptions) *Queue {
+	options.Workers = max(options.Workers, 1)
+	options.MaxAttempts = max(options.MaxAttempts, 1)
+	if options.Backoff <= 0 {
+		options.Backoff = 100 * time.Millisecond
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = math.MaxInt64
+	}
+	q := &Queue{
+		ctx:     ctx,
+		options: options,
+		jobs:    make(chan Job, options.Buffer),
+		results: make(chan Result, options.Workers),
+		done:    make(chan struct{}),
+	}
+	q.workers.Add(options.Workers)
+	for range options.Workers {
+		go q.work()
+	}
+	return q
+}
+
+// 提交命令, 返回任务 ID
+func (q *Queue) Submit(ctx context.Context, command Command) (uint64, error) {
+	return q.SubmitJob(ctx, Job{Command: command})
+}
+
+// 提交任务, 队列已满时阻塞直到有空位、ctx 取消、队列的 ctx 取消或队列关闭
+func (q *Queue) SubmitJob(ctx context.Context, job Job) (uint64, error) {
+	if job.Command == nil {
+		return 0, ErrNilCommand
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return 0, ErrQueueClosed
+	}
+	q.senders.Add(1)
+	q.mu.Unlock()
+	defer q.senders.Done()
+
+	if job.Timeout == 0 {
+		job.Timeout = q.options.Timeout
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = q.options.MaxAttempts
+	}
+	job.ID = q.nextID.Add(1)
+	select {
+	case q.jobs <- job:
+		return job.ID, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-q.ctx.Done():
+		return 0, q.ctx.Err()
+	case <-q.done:
+		return 0, ErrQueueClosed
+	}
+}
+
+func (q *Queue) Results() <-chan Result {
+	return q.results
+}
+
+// 停止接收新任务, 正在等待空位的提交返回 ErrQueueClosed。
+// 等待已提交的任务执行完后关闭 Results, 因此 Results 没有人读取时 Close 也会阻塞
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.done)
+	q.mu.Unlock()
+	q.senders.Wait()
+	close(q.jobs)
+	q.workers.Wait()
+	close(q.results)
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for job := range q.jobs {
+		q.results <- q.run(job)
+	}
+}
+
+// 执行任务, 失败时重试, 两次执行之间的等待时间按指数增长
+func (q *Queue) run(job Job) Result {
+	result := Result{Job: job}
+	backoff := min(q.options.Backoff, q.options.MaxBackoff)
+	for {
+		if err := q.ctx.Err(); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Attempts++
+		result.Err = q.execute(job)
+		if result.Err == nil || result.Attempts >= job.MaxAttempts || q.ctx.Err() != nil || errors.Is(result.Err, ErrAbandoned) {
+			return result
+		}
+		select {
+		case <-time.After(backoff):
+		case <-q.ctx.Done():
+			return result
+		}
+		backoff = q.nextBackoff(backoff)
+	}
+}
+
+// 等待时间加倍, 不超过 MaxBackoff。先比较再加倍, 避免溢出
+func (q *Queue) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff > q.options.MaxBackoff/2 {
+		return q.options.MaxBackoff
+	}
+	return backoff * 2
+}
+
+func (q *Queue) execute(job Job) error {
+	ctx := q.ctx
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+	if command, ok := job.Command.(ContextCommand); ok {
+		return safely(func() error { return command.ExecuteContext(ctx) })
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- safely(func() error {
+			_, err := job.Command.Execute()
+			return err
+		})
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrAbandoned, ctx.Err())
+	}
+}
+
+// 执行命令, 命令 panic 时转换为 ErrPanicked 错误, 不让一个命令拖垮 worker 和整个进程
+func safely(execute func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanicked, r)
+		}
+	}()
+	return execute()
+}
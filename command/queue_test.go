@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 提交一个任务, 关闭队列后返回它的结果
+func runJob(t *testing.T, job Job) Result {
+	t.Helper()
+	queue := NewQueue(context.Background(), QueueOptions{Backoff: time.Millisecond})
+	if _, err := queue.SubmitJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	go queue.Close()
+	var results []Result
+	for result := range queue.Results() {
+		results = append(results, result)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	return results[0]
+}
+
+func TestQueueRetries(t *testing.T) {
+	errUnavailable := errors.New("service unavailable")
+	failTimes := func(n int32) Command {
+		var calls atomic.Int32
+		return CommandFunc(func(ctx context.Context) error {
+			if calls.Add(1) <= n {
+				return errUnavailable
+			}
+			return nil
+		})
+	}
+	waitForCancel := CommandFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	// 宏命令不支持取消, 超时后队列只能放弃它
+	sleep := NewMacroCommand("sleep", CommandFunc(func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}))
+	panics := CommandFunc(func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	for _, test := range []struct {
+		name     string
+		job      Job
+		attempts int
+		err      error
+	}{
+		{"success after retries", Job{Command: failTimes(2), MaxAttempts: 3}, 3, nil},
+		{"attempts exhausted", Job{Command: failTimes(5), MaxAttempts: 2}, 2, errUnavailable},
+		{"context command retried after timeout", Job{Command: waitForCancel, Timeout: 5 * time.Millisecond, MaxAttempts: 2}, 2, context.DeadlineExceeded},
+		{"plain command abandoned without retry", Job{Command: sleep, Timeout: 5 * time.Millisecond, MaxAttempts: 3}, 1, ErrAbandoned},
+		{"panic", Job{Command: panics, MaxAttempts: 1}, 1, ErrPanicked},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := runJob(t, test.job)
+			if result.Attempts != test.attempts {
+				t.Errorf("attempts = %d, want %d", result.Attempts, test.attempts)
+			}
+			if test.err == nil && result.Err != nil || test.err != nil && !errors.Is(result.Err, test.err) {
+				t.Errorf("err = %v, want %v", result.Err, test.err)
+			}
+		})
+	}
+}
+
+// worker 阻塞在没有人读取的 Results 上时, 等待空位的提交在 Close 之后返回
+func TestQueueCloseCancelsPendingSubmit(t *testing.T) {
+	queue := NewQueue(context.Background(), QueueOptions{Workers: 1})
+	noop := CommandFunc(func(ctx context.Context) error { return nil })
+	// 第一个结果占满 Results 的缓冲, worker 取走第二个任务后阻塞在发送结果上
+	for range 2 {
+		if _, err := queue.Submit(context.Background(), noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submitted := make(chan error)
+	go func() {
+		_, err := queue.Submit(context.Background(), noop)
+		submitted <- err
+	}()
+	closed := make(chan struct{})
+	go func() {
+		queue.Close()
+		close(closed)
+	}()
+
+	select {
+	case err := <-submitted:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("pending submit: err = %v, want %v", err, ErrQueueClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending submit still blocked after Close")
+	}
+	n := 0
+	for range queue.Results() {
+		n++
+	}
+	<-closed
+	if n != 2 {
+		t.Errorf("got %d results, want 2", n)
+	}
+	if _, err := queue.Submit(context.Background(), noop); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("submit after Close: err = %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		max  time.Duration
+		want time.Duration
+	}{
+		{"capped", time.Second, time.Second},
+		{"unlimited does not overflow", math.MaxInt64, math.MaxInt64},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			queue := &Queue{options: QueueOptions{MaxBackoff: test.max}}
+			backoff := time.Millisecond
+			for range 100 {
+				next := queue.nextBackoff(backoff)
+				if next < backoff {
+					t.Fatalf("backoff decreased from %v to %v", backoff, next)
+				}
+				backoff = next
+			}
+			if backoff != test.want {
+				t.Errorf("backoff = %v, want %v", backoff, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnboundButton = errors.New("command: button is not bound")
+	ErrInvalidSlot   = errors.New("command: invalid slot")
+)
+
+// 空命令, 没有绑定命令的按钮使用它, 这样插槽中永远不会出现 nil
+type NoCommand struct{}
+
+func (NoCommand) Execute() (UndoFunc, error) {
+	return func() error { return nil }, nil
+}
+
+// 遥控器上的一个插槽, Name 为空表示按位置访问的插槽
+type Slot struct {
+	Name    string
+	Command Command
+}
+
+func (s Slot) bound() bool {
+	_, none := s.Command.(NoCommand)
+	return !none
+}
+
+// 遥控器最多的插槽数, 包括命名插槽。
+// 插槽位置可能来自配置文件, 不能让配置决定分配多少内存
+const MaxSlots = 256
+
+// 调用者角色, 按下的按钮都记入历史, 可以撤销和重做。
+// 按位置访问的插槽和命名插槽分开保存, 互不影响, 绑定时按需增加
+type RemoteControl struct {
+	slots   []Slot
+	named   map[string]*Slot
+	names   []string // 命名插槽按绑定的先后顺序
+	history *CommandHistory
+}
+
+// 创建带有 slots 个空插槽的遥控器, 最多 MaxSlots 个。history 为 nil 时使用不限长度的历史
+func NewRemoteControl(slots int, history *CommandHistory) *RemoteControl {
+	if history == nil {
+		history = NewCommandHistory(0)
+	}
+	r := &RemoteControl{named: make(map[string]*Slot), history: history}
+	r.grow(min(slots, MaxSlots))
+	return r
+}
+
+func (r *RemoteControl) grow(n int) {
+	for len(r.slots) < n {
+		r.slots = append(r.slots, Slot{Command: NoCommand{}})
+	}
+}
+
+// 插槽总数, 包括命名插槽
+func (r *RemoteControl) size() int {
+	return len(r.slots) + len(r.named)
+}
+
+// 按钮可以绑定单个命令, 也可以绑定宏命令。
+// index 超出现有插槽时遥控器自动增加插槽, 但插槽总数不能超过 MaxSlots; command 为 nil 时解除绑定
+func (r *RemoteControl) SetCommand(index int, command Command) error {
+	if index < 0 || index >= MaxSlots || index >= len(r.slots) && index+1+len(r.named) > MaxSlots {
+		return fmt.Errorf("%w: %d", ErrInvalidSlot, index)
+	}
+	r.grow(index + 1)
+	r.slots[index].Command = orNoCommand(command)
+	return nil
+}
+
+// 绑定命名插槽, 插槽不存在时新建一个
+func (r *RemoteControl) Bind(name string, command Command) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidSlot)
+	}
+	if slot, ok := r.named[name]; ok {
+		slot.Command = orNoCommand(command)
+		return nil
+	}
+	if r.size() >= MaxSlots {
+		return fmt.Errorf("%w: %q exceeds %d slots", ErrInvalidSlot, name, MaxSlots)
+	}
+	r.named[name] = &Slot{Name: name, Command: orNoCommand(command)}
+	r.names = append(r.names, name)
+	return nil
+}
+
+// 解除命名插槽的绑定, 插槽本身保留
+func (r *RemoteControl) Unbind(name string) error {
+	slot, ok := r.named[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnboundButton, name)
+	}
+	slot.Command = NoCommand{}
+	return nil
+}
+
+func orNoCommand(command Command) Command {
+	if command == nil {
+		return NoCommand{}
+	}
+	return command
+}
+
+func (r *RemoteControl) PressButton(index int) error {
+	if index < 0 || index >= len(r.slots) || !r.slots[index].bound() {
+		return fmt.Errorf("%w: %d", ErrUnboundButton, index)
+	}
+	return r.history.Execute(r.slots[index].Command)
+}
+
+func (r *RemoteControl) Press(name string) error {
+	slot, ok := r.named[name]
+	if !ok || !slot.bound() {
+		return fmt.Errorf("%w: %q", ErrUnboundButton, name)
+	}
+	return r.history.Execute(slot.Command)
+}
+
+// 返回所有插槽的副本, 先按位置顺序列出位置插槽, 再按绑定的先后列出命名插槽
+func (r *RemoteControl) Bindings() []Slot {
+	bindings := append([]Slot(nil), r.slots...)
+	for _, name := range r.names {
+		bindings = append(bindings, *r.named[name])
+	}
+	return bindings
+}
+
+func (r *RemoteControl) PressUndo() error {
+	return r.history.Undo()
+}
+
+func (r *RemoteControl) PressRedo() error {
+	return r.history.Redo()
+}
+
+// 从文本配置加载按键绑定, 每行一个绑定:
+//
+//	# 注释
+//	0     = living-room.on
+//	movie = movie-mode
+//	1     = none
+//
+// 左边是插槽的位置或名字, 右边是 commands 中的命令名, none 表示解除绑定。
+// 配置有错误或者需要的插槽超过 MaxSlots 时不修改任何绑定
+func (r *RemoteControl) LoadBindings(config io.Reader, commands map[string]Command) error {
+	type binding struct {
+		slot    string
+		command Command
+	}
+	var bindings []binding
+	// 应用配置后的插槽数, 用来在修改之前检查 MaxSlots
+	positional, added := len(r.slots), make(map[string]bool)
+	scanner := bufio.NewScanner(config)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		slot, name, ok := strings.Cut(text, "=")
+		slot, name = strings.TrimSpace(slot), strings.TrimSpace(name)
+		if !ok || slot == "" || name == "" {
+			return fmt.Errorf("command: bindings line %d: expected \"slot = command\"", line)
+		}
+		if index, err := strconv.Atoi(slot); err == nil {
+			if index < 0 || index >= MaxSlots {
+				return fmt.Errorf("command: bindings line %d: %w: %d", line, ErrInvalidSlot, index)
+			}
+			positional = max(positional, index+1)
+		} else if _, ok := r.named[slot]; !ok {
+			added[slot] = true
+		}
+		if positional+len(r.named)+len(added) > MaxSlots {
+			return fmt.Errorf("command: bindings line %d: %w: more than %d slots", line, ErrInvalidSlot, MaxSlots)
+		}
+		var command Command
+		if name != "none" {
+			command, ok = commands[name]
+			if !ok {
+				return fmt.Errorf("command: bindings line %d: unknown command %q", line, name)
+			}
+		}
+		bindings = append(bindings, binding{slot: slot, command: command})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, b := range bindings {
+		if index, err := strconv.Atoi(b.slot); err == nil {
+			r.SetCommand(index, b.command)
+		} else {
+			r.Bind(b.slot, b.command)
+		}
+	}
+	return nil
+}
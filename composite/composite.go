@@ -1 +1,327 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 路径分隔符, 路径形如 "/root/dir/file"
+const PathSeparator = "/"
+
+var (
+	ErrLeafNode      = errors.New("composite: leaf node cannot have children")
+	ErrInvalidName   = errors.New("composite: invalid node name")
+	ErrDuplicateName = errors.New("composite: duplicate child name")
+	ErrHasParent     = errors.New("composite: node already has a parent")
+	ErrCycle         = errors.New("composite: node cannot be added to its own subtree")
+	ErrNotFound      = errors.New("composite: node not found")
+	ErrNilComponent  = errors.New("composite: nil component")
+)
+
+// Component 抽象组件角色, 叶子节点和组合节点的统一接口
+type Component interface {
+	Name() string
+	Size() int64
+	Parent() *Composite
+	Children() []Component
+	Add(child Component) error
+	Remove(name string) error
+	Child(name string) (Component, bool)
+	Print(w io.Writer, indent string)
+	setParent(parent *Composite)
+}
+
+// node 叶子节点和组合节点共有的属性
+type node struct {
+	name   string
+	parent *Composite
+}
+
+func (n *node) Name() string {
+	return n.name
+}
+
+func (n *node) Parent() *Composite {
+	return n.parent
+}
+
+func (n *node) setParent(parent *Composite) {
+	n.parent = parent
+}
+
+// Leaf 叶子节点角色, 没有子节点
+type Leaf struct {
+	node
+	size int64
+}
+
+func NewLeaf(name string, size int64) *Leaf {
+	return &Leaf{node: node{name: name}, size: size}
+}
+
+func (l *Leaf) Size() int64 {
+	return l.size
+}
+
+func (l *Leaf) Children() []Component {
+	return nil
+}
+
+func (l *Leaf) Add(child Component) error {
+	return ErrLeafNode
+}
+
+func (l *Leaf) Remove(name string) error {
+	return ErrLeafNode
+}
+
+func (l *Leaf) Child(name string) (Component, bool) {
+	return nil, false
+}
+
+func (l *Leaf) Print(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%s%s (%d)\n", indent, l.name, l.size)
+}
+
+// Composite 组合节点角色, 维护一个有序的子节点集合
+type Composite struct {
+	node
+	children []Component
+}
+
+func NewComposite(name string) *Composite {
+	return &Composite{node: node{name: name}}
+}
+
+// Size 递归汇总所有子节点的大小
+func (c *Composite) Size() int64 {
+	var total int64
+	for _, child := range c.children {
+		total += child.Size()
+	}
+	return total
+}
+
+// Children 返回子节点列表的副本, 调用方修改它不会影响树结构
+func (c *Composite) Children() []Component {
+	children := make([]Component, len(c.children))
+	copy(children, c.children)
+	return children
+}
+
+func (c *Composite) Add(child Component) error {
+	if isNil(child) {
+		return ErrNilComponent
+	}
+	name := child.Name()
+	if !validName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if child.Parent() != nil {
+		return fmt.Errorf("%w: %q", ErrHasParent, name)
+	}
+	if _, ok := c.Child(name); ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateName, name)
+	}
+	// 不允许把祖先节点(包括自己)挂到自己下面, 否则会形成环
+	for p := c; p != nil; p = p.parent {
+		if Component(p) == child {
+			return fmt.Errorf("%w: %q", ErrCycle, name)
+		}
+	}
+	child.setParent(c)
+	c.children = append(c.children, child)
+	return nil
+}
+
+func (c *Composite) Remove(name string) error {
+	for i, child := range c.children {
+		if child.Name() == name {
+			child.setParent(nil)
+			c.children = append(c.children[:i], c.children[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrNotFound, name)
+}
+
+func (c *Composite) Child(name string) (Component, bool) {
+	for _, child := range c.children {
+		if child.Name() == name {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+// Print 递归打印整棵子树, 子节点比父节点多缩进一级
+func (c *Composite) Print(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%s%s/ (%d)\n", indent, c.name, c.Size())
+	for _, child := range c.children {
+		child.Print(w, indent+"  ")
+	}
+}
+
+// Path 返回节点从根开始的绝对路径
+func Path(c Component) string {
+	names := []string{c.Name()}
+	for p := c.Parent(); p != nil; p = p.Parent() {
+		names = append(names, p.Name())
+	}
+	var b strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		b.WriteString(PathSeparator)
+		b.WriteString(names[i])
+	}
+	return b.String()
+}
+
+// Find 按绝对路径查找节点, 路径的第一段必须是根节点的名字
+func Find(root Component, path string) (Component, error) {
+	segments := splitPath(path)
+	if len(segments) == 0 || segments[0] != root.Name() {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, path)
+	}
+	current := root
+	for _, name := range segments[1:] {
+		child, ok := current.Child(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrNotFound, path)
+		}
+		current = child
+	}
+	return current, nil
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, PathSeparator) {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// 只有本包的类型实现了 Component, 因此列举它们就能识别包在接口里的 nil 指针
+func isNil(c Component) bool {
+	switch c := c.(type) {
+	case nil:
+		return true
+	case *Leaf:
+		return c == nil
+	case *Composite:
+		return c == nil
+	}
+	return false
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, PathSeparator)
+}
+
+func main() {
+	root := NewComposite("root")
+	docs := NewComposite("docs")
+	src := NewComposite("src")
+
+	root.Add(docs)
+	root.Add(src)
+	root.Add(NewLeaf("README.md", 120))
+	docs.Add(NewLeaf("guide.md", 300))
+	src.Add(NewLeaf("main.go", 512))
+	src.Add(NewLeaf("util.go", 256))
+
+	root.Print(os.Stdout, "")
+	// Output:
+	// root/ (1188)
+	//   docs/ (300)
+	//     guide.md (300)
+	//   src/ (768)
+	//     main.go (512)
+	//     util.go (256)
+	//   README.md (120)
+
+	file, err := Find(root, "/root/src/main.go")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(Path(file), file.Size()) // /root/src/main.go 512
+
+	src.Remove("util.go")
+	fmt.Println(root.Size()) // 932
+
+	_, err = Find(root, "/root/src/util.go")
+	fmt.Println(err) // composite: node not found: "/root/src/util.go"
+
+	fmt.Println(docs.Add(root)) // composite: node cannot be added to its own subtree: "root"
+	fmt.Println(docs.Add(nil))  // composite: nil component
+
+	// 前序遍历, 跳过 docs 子树
+	Walk(context.Background(), root, PreOrder, func(c Component, depth int) error {
+		fmt.Println(strings.Repeat("  ", depth) + c.Name())
+		if c.Name() == "docs" {
+			return SkipChildren
+		}
+		return nil
+	})
+	// Output:
+	// root
+	//   docs
+	//   src
+	//     main.go
+	//   README.md
+
+	// 广度优先遍历, 找到第一个叶子节点后提前结束
+	Walk(context.Background(), root, BreadthFirst, func(c Component, depth int) error {
+		if _, ok := c.(*Leaf); ok {
+			fmt.Println("first leaf:", Path(c)) // first leaf: /root/README.md
+			return Stop
+		}
+		return nil
+	})
+
+	// 后序遍历, 进入 src 时剪枝, src 本身仍被访问
+	WalkPostOrder(context.Background(), root, func(c Component, depth int) error {
+		if c.Name() == "src" {
+			return SkipChildren
+		}
+		return nil
+	}, func(c Component, depth int) error {
+		fmt.Println(strings.Repeat("  ", depth) + c.Name())
+		return nil
+	})
+	// Output:
+	//     guide.md
+	//   docs
+	//   src
+	//   README.md
+	// root
+
+	// 包装过的 Stop 同样结束遍历; 后序遍历的访问回调不能再剪枝
+	err = Walk(context.Background(), root, PreOrder, func(c Component, depth int) error {
+		return fmt.Errorf("found %s: %w", c.Name(), Stop)
+	})
+	fmt.Println(err) // <nil>
+	err = Walk(context.Background(), root, PostOrder, func(c Component, depth int) error {
+		return SkipChildren
+	})
+	fmt.Println(err) // composite: SkipChildren returned after children were visited in post-order walk
+
+	// 后序遍历, 上下文取消后遍历中止
+	ctx, cancel := context.WithCancel(context.Background())
+	err = Walk(ctx, root, PostOrder, func(c Component, depth int) error {
+		fmt.Println(c.Name())
+		cancel()
+		return nil
+	})
+	fmt.Println(err)
+	// Output:
+	// guide.md
+	// context canceled
+}
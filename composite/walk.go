@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// 遍历回调可以返回的特殊错误, 用来控制遍历过程而不是表示失败
+var (
+	// SkipChildren 跳过当前节点的子树。后序遍历访问节点时子树已经访问过, 要剪枝需在 WalkPostOrder 的 enter 中返回
+	SkipChildren = errors.New("composite: skip children")
+	// Stop 立即结束遍历, Walk 返回 nil
+	Stop = errors.New("composite: stop walk")
+)
+
+// ErrSkipInPostOrder 后序遍历的访问回调返回了 SkipChildren
+var ErrSkipInPostOrder = errors.New("composite: SkipChildren returned after children were visited in post-order walk")
+
+// VisitFunc 访问回调, depth 为节点相对遍历起点的深度, 起点为 0
+type VisitFunc func(c Component, depth int) error
+
+// Order 遍历策略
+type Order int
+
+const (
+	PreOrder Order = iota
+	PostOrder
+	BreadthFirst
+)
+
+func (o Order) String() string {
+	switch o {
+	case PreOrder:
+		return "pre-order"
+	case PostOrder:
+		return "post-order"
+	case BreadthFirst:
+		return "breadth-first"
+	}
+	return "unknown"
+}
+
+// Walk 按指定策略遍历以 root 为根的子树。
+// 每访问一个节点前都会检查 ctx, 取消后返回 ctx.Err();
+// 回调返回 SkipChildren 或 Stop(包括包装了它们的错误)时按约定剪枝或结束, 返回其他错误则中止遍历并原样返回。
+// 后序遍历中返回 SkipChildren 得到 ErrSkipInPostOrder, 需要剪枝时使用 WalkPostOrder。
+// 遍历使用显式栈/队列, 只保存当前路径或当前层的节点, 不会预先展开整棵树。
+func Walk(ctx context.Context, root Component, order Order, fn VisitFunc) error {
+	switch order {
+	case PreOrder:
+		return result(walkPreOrder(ctx, root, fn))
+	case PostOrder:
+		return WalkPostOrder(ctx, root, nil, fn)
+	case BreadthFirst:
+		return result(walkBreadthFirst(ctx, root, fn))
+	}
+	return errors.New("composite: unknown walk order")
+}
+
+// WalkPostOrder 后序遍历, enter 在进入节点、访问它的子树之前调用, 返回 SkipChildren 时不访问子树,
+// 节点本身仍然交给 leave 访问。enter 可以为 nil; leave 返回 SkipChildren 时得到 ErrSkipInPostOrder
+func WalkPostOrder(ctx context.Context, root Component, enter, leave VisitFunc) error {
+	return result(walkPostOrder(ctx, root, enter, leave))
+}
+
+// 把控制遍历的特殊错误转换为 Walk 的返回值
+func result(err error) error {
+	if errors.Is(err, Stop) || errors.Is(err, SkipChildren) {
+		return nil
+	}
+	return err
+}
+
+// frame 深度优先遍历时的栈帧, 记录一个节点和它下一个待访问子节点的位置
+type frame struct {
+	node     Component
+	children []Component
+	next     int
+	depth    int
+}
+
+func walkPreOrder(ctx context.Context, root Component, fn VisitFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := fn(root, 0); err != nil {
+		return err
+	}
+	stack := []*frame{{node: root, children: root.Children()}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next == len(top.children) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		child := top.children[top.next]
+		top.next++
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		depth := top.depth + 1
+		switch err := fn(child, depth); {
+		case err == nil:
+			stack = append(stack, &frame{node: child, children: child.Children(), depth: depth})
+		case errors.Is(err, SkipChildren):
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func walkPostOrder(ctx context.Context, root Component, enter, leave VisitFunc) error {
+	var stack []*frame
+	// 进入节点, enter 剪枝时节点没有要访问的子节点
+	push := func(node Component, depth int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f := &frame{node: node, depth: depth}
+		if enter != nil {
+			switch err := enter(node, depth); {
+			case err == nil:
+			case errors.Is(err, SkipChildren):
+				stack = append(stack, f)
+				return nil
+			default:
+				return err
+			}
+		}
+		f.children = node.Children()
+		stack = append(stack, f)
+		return nil
+	}
+	if err := push(root, 0); err != nil {
+		return err
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(top.children) {
+			child := top.children[top.next]
+			top.next++
+			if err := push(child, top.depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		stack = stack[:len(stack)-1]
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch err := leave(top.node, top.depth); {
+		case err == nil:
+		case errors.Is(err, SkipChildren):
+			return ErrSkipInPostOrder
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func walkBreadthFirst(ctx context.Context, root Component, fn VisitFunc) error {
+	type item struct {
+		node  Component
+		depth int
+	}
+	queue := []item{{node: root}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue[0] = item{}
+		queue = queue[1:]
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch err := fn(current.node, current.depth); {
+		case err == nil:
+			for _, child := range current.node.Children() {
+				queue = append(queue, item{node: child, depth: current.depth + 1})
+			}
+		case errors.Is(err, SkipChildren):
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 诊断的严重程度
+type Severity int
+
+const (
+	SeverityWarning Severity = iota // 可能有问题, 但表达式仍然可以求值
+	SeverityError                   // 求值到这里时一定会出错
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// 静态分析发现的问题
+type Diagnostic struct {
+	Pos      Position // 没有位置信息时为零值
+	Severity Severity
+	Pass     string // 报告问题的 pass
+	Msg      string
+}
+
+func (d Diagnostic) String() string {
+	pos := "-"
+	if d.Pos != (Position{}) {
+		pos = d.Pos.String()
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, d.Severity, d.Msg, d.Pass)
+}
+
+// 分析单元, 保存被分析的表达式、分析的输入和各个 pass 的结果。
+// 做变换的 pass 会替换 Expr, 新节点沿用被替换节点的位置
+type Unit struct {
+	Expr      Expression
+	Positions Positions // 可以为 nil, 此时诊断不带位置
+	// 调用的函数在这里查找, 为 nil 时使用内置函数
+	Functions *FunctionRegistry
+	// 环境中变量的类型, 为 nil 时所有变量都视为已定义的任意类型
+	Variables map[string]ParamType
+
+	FreeVariables     []string  // 表达式读取的外部变量, 按名字排序
+	AssignedVariables []string  // 表达式赋值的外部变量, 按名字排序
+	Type              ParamType // 表达式结果可能的类型, 为 0 时表示求值一定会出错
+	Diagnostics       []Diagnostic
+
+	pass string
+}
+
+func NewUnit(expr Expression, positions Positions) *Unit {
+	return &Unit{Expr: expr, Positions: positions}
+}
+
+// 报告 expr 处的问题
+func (u *Unit) Report(expr Expression, severity Severity, format string, args ...any) {
+	u.Diagnostics = append(u.Diagnostics, Diagnostic{
+		Pos:      u.Positions.Of(expr),
+		Severity: severity,
+		Pass:     u.pass,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (u *Unit) HasErrors() bool {
+	return slices.ContainsFunc(u.Diagnostics, func(d Diagnostic) bool {
+		return d.Severity == SeverityError
+	})
+}
+
+// 用 replacement 替换 expr 时调用, 让新节点继承原节点的位置
+func (u *Unit) replaced(expr, replacement Expression) {
+	if _, ok := u.Positions[replacement]; ok || u.Positions == nil {
+		return
+	}
+	if pos, ok := u.Positions[expr]; ok {
+		u.Positions[replacement] = pos
+	}
+}
+
+func (u *Unit) functions() *FunctionRegistry {
+	if u.Functions == nil {
+		u.Functions = NewFunctionRegistry()
+	}
+	return u.Functions
+}
+
+// 分析 pass, 读取和修改分析单元
+type Pass interface {
+	Name() string
+	Run(u *Unit)
+}
+
+// 依次运行一组 pass
+type Analyzer struct {
+	passes []Pass
+}
+
+// 不指定 pass 时使用 DefaultPasses
+func NewAnalyzer(passes ...Pass) *Analyzer {
+	if len(passes) == 0 {
+		passes = DefaultPasses()
+	}
+	return &Analyzer{passes: passes}
+}
+
+// 先做分析再做优化: 诊断针对源码中的表达式, 而不是优化后的表达式
+func DefaultPasses() []Pass {
+	return []Pass{FreeVariables{}, TypeInference{}, DivisionByZero{}, DeadBranchElimination{}, ConstantFolding{}}
+}
+
+func (a *Analyzer) Run(u *Unit) {
+	for _, pass := range a.passes {
+		u.pass = pass.Name()
+		pass.Run(u)
+	}
+	u.pass = ""
+	slices.SortStableFunc(u.Diagnostics, func(x, y Diagnostic) int {
+		if x.Pos.Line != y.Pos.Line {
+			return x.Pos.Line - y.Pos.Line
+		}
+		return x.Pos.Column - y.Pos.Column
+	})
+}
+
+// 解析源码并运行默认的 pass, variables 为环境中变量的类型, 可以为 nil
+func Analyze(src string, variables map[string]ParamType) (*Unit, error) {
+	expr, positions, err := ParseWithPositions(src)
+	if err != nil {
+		return nil, err
+	}
+	u := NewUnit(expr, positions)
+	u.Variables = variables
+	NewAnalyzer().Run(u)
+	return u, nil
+}
+
+// 表达式的直接子表达式, 按求值顺序排列
+func children(expr Expression) []Expression {
+	switch e := expr.(type) {
+	case *NegateExpression:
+		return []Expression{e.operand}
+	case *NotExpression:
+		return []Expression{e.operand}
+	case *ListExpression:
+		return e.elements
+	case *CallExpression:
+		return e.args
+	case *AssignExpression:
+		return []Expression{e.value}
+	case *LetExpression:
+		result := make([]Expression, 0, len(e.bindings)+1)
+		for _, b := range e.bindings {
+			result = append(result, b.value)
+		}
+		return append(result, e.body)
+	}
+	if _, left, right, ok := binaryParts(expr); ok {
+		return []Expression{left, right}
+	}
+	return nil
+}
+
+// 用新的子表达式构造一个同类型的节点, kids 与 children(expr) 一一对应
+func withChildren(expr Expression, kids []Expression) Expression {
+	switch e := expr.(type) {
+	case *NegateExpression:
+		return &NegateExpression{operand: kids[0]}
+	case *NotExpression:
+		return &NotExpression{operand: kids[0]}
+	case *ListExpression:
+		return &ListExpression{elements: kids}
+	case *CallExpression:
+		return &CallExpression{name: e.name, args: kids}
+	case *AssignExpression:
+		return &AssignExpression{name: e.name, value: kids[0]}
+	case *LetExpression:
+		let := &LetExpression{bindings: make([]binding, len(e.bindings)), body: kids[len(kids)-1]}
+		for i, b := range e.bindings {
+			let.bindings[i] = binding{name: b.name, value: kids[i]}
+		}
+		return let
+	}
+	if op, _, _, ok := binaryParts(expr); ok {
+		return newBinary(op, kids[0], kids[1])
+	}
+	return expr
+}
+
+// 自顶向下改写表达式树: fn 反复作用于节点直到不再变化, 再递归改写结果的子表达式。
+// fn 每次替换都必须让树变小, 否则不会终止。
+// 没有变化的子树保持原样, 有变化的节点会复制一份并继承原节点的位置
+func (u *Unit) rewrite(expr Expression, fn func(Expression) Expression) Expression {
+	for replacement := fn(expr); replacement != expr; replacement = fn(expr) {
+		u.replaced(expr, replacement)
+		expr = replacement
+	}
+	kids := children(expr)
+	var rewritten []Expression
+	for i, kid := range kids {
+		r := u.rewrite(kid, fn)
+		if r != kid && rewritten == nil {
+			rewritten = slices.Clone(kids)
+		}
+		if rewritten != nil {
+			rewritten[i] = r
+		}
+	}
+	if rewritten == nil {
+		return expr
+	}
+	result := withChildren(expr, rewritten)
+	u.replaced(expr, result)
+	return result
+}
+
+// 按作用域收集表达式读取和赋值的外部变量, 结果按名字排序。
+// let 的绑定依次生效, 绑定的值可以引用前面的绑定
+func freeVariables(expr Expression) (reads, writes []string) {
+	var visit func(expr Expression, bound map[string]int)
+	visit = func(expr Expression, bound map[string]int) {
+		switch e := expr.(type) {
+		case *VariableExpression:
+			if bound[e.name] == 0 && !slices.Contains(reads, e.name) {
+				reads = append(reads, e.name)
+			}
+			return
+		case *AssignExpression:
+			visit(e.value, bound)
+			if bound[e.name] == 0 && !slices.Contains(writes, e.name) {
+				writes = append(writes, e.name)
+			}
+			return
+		case *LetExpression:
+			for _, b := range e.bindings {
+				visit(b.value, bound)
+				bound[b.name]++
+			}
+			visit(e.body, bound)
+			for _, b := range e.bindings {
+				bound[b.name]--
+			}
+			return
+		}
+		for _, child := range children(expr) {
+			visit(child, bound)
+		}
+	}
+	visit(expr, make(map[string]int))
+	slices.Sort(reads)
+	slices.Sort(writes)
+	return reads, writes
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 操作码
+type Opcode uint8
+
+const (
+	OpConst       Opcode = iota // 压入常量 constants[arg]
+	OpLoad                      // 压入环境中的变量 names[arg] 的值
+	OpStore                     // 把栈顶的值赋给环境中已定义的变量 names[arg], 值保留在栈顶
+	OpLoadLocal                 // 压入 let 变量的槽位 arg 的值
+	OpStoreLocal                // 把栈顶的值赋给 let 变量的槽位 arg, 值保留在栈顶
+	OpDefineLocal               // 弹出栈顶的值, 存入 let 变量的槽位 arg
+	OpAdd                       // 弹出 b, a, 压入 a + b, 以下二元运算相同
+	OpSub                       //
+	OpMul                       //
+	OpDiv                       //
+	OpMod                       //
+	OpEq                        //
+	OpNe                        //
+	OpLt                        //
+	OpLe                        //
+	OpGt                        //
+	OpGe                        //
+	OpNeg                       // 栈顶取负
+	OpNot                       // 栈顶取逻辑非
+	OpBool                      // 检查栈顶是布尔值, 用于 and/or 的右操作数, arg 为运算符
+	OpJumpIfFalse               // 栈顶为 false 时跳转到 arg, 否则弹出; 栈顶不是布尔值时报错
+	OpJumpIfTrue                // 栈顶为 true 时跳转到 arg, 否则弹出; 栈顶不是布尔值时报错
+	OpCall                      // 调用 calls[arg] 描述的函数
+	OpList                      // 弹出 arg 个值组成列表
+)
+
+var opcodeNames = [...]string{
+	OpConst:       "CONST",
+	OpLoad:        "LOAD",
+	OpStore:       "STORE",
+	OpLoadLocal:   "LOAD_LOCAL",
+	OpStoreLocal:  "STORE_LOCAL",
+	OpDefineLocal: "DEFINE_LOCAL",
+	OpAdd:         "ADD",
+	OpSub:         "SUB",
+	OpMul:         "MUL",
+	OpDiv:         "DIV",
+	OpMod:         "MOD",
+	OpEq:          "EQ",
+	OpNe:          "NE",
+	OpLt:          "LT",
+	OpLe:          "LE",
+	OpGt:          "GT",
+	OpGe:          "GE",
+	OpNeg:         "NEG",
+	OpNot:         "NOT",
+	OpBool:        "BOOL",
+	OpJumpIfFalse: "JUMP_IF_FALSE",
+	OpJumpIfTrue:  "JUMP_IF_TRUE",
+	OpCall:        "CALL",
+	OpList:        "LIST",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("OP(%d)", int(op))
+}
+
+// 二元运算符和操作码的对应关系
+var binaryOpcodes = map[TokenKind]Opcode{
+	PLUS: OpAdd, MINUS: OpSub, STAR: OpMul, SLASH: OpDiv, PERCENT: OpMod,
+	EQ: OpEq, NE: OpNe, LT: OpLt, LE: OpLe, GT: OpGt, GE: OpGe,
+}
+
+// 操作码对应的运算符, 虚拟机执行时使用, 用数组避免 map 查找
+var opcodeOperators = [...]TokenKind{
+	OpAdd: PLUS, OpSub: MINUS, OpMul: STAR, OpDiv: SLASH, OpMod: PERCENT,
+	OpEq: EQ, OpNe: NE, OpLt: LT, OpLe: LE, OpGt: GT, OpGe: GE,
+}
+
+// 指令, arg 的含义由操作码决定
+type Instruction struct {
+	Op  Opcode
+	Arg int32
+}
+
+type callSite struct {
+	name string
+	argc int
+}
+
+// 编译后的字节码程序, 可以在多个 Context 上重复执行。
+// let 定义的变量在编译时分配槽位, 执行时不需要按名字查找; 只有环境中的变量按名字查找
+type Program struct {
+	code      []Instruction
+	constants []Value
+	names     []string // 环境中的变量名
+	locals    []string // let 变量的槽位, 下标为槽位号, 仅用于反汇编
+	calls     []callSite
+	stackSize int // 执行时操作数栈的最大深度
+}
+
+// 反汇编, 便于调试
+func (p *Program) String() string {
+	var b strings.Builder
+	for pc, in := range p.code {
+		var operand string
+		switch in.Op {
+		case OpConst:
+			operand = p.constants[in.Arg].String()
+		case OpLoad, OpStore:
+			operand = p.names[in.Arg]
+		case OpLoadLocal, OpStoreLocal, OpDefineLocal:
+			operand = fmt.Sprintf("%d (%s)", in.Arg, p.locals[in.Arg])
+		case OpCall:
+			operand = fmt.Sprintf("%s/%d", p.calls[in.Arg].name, p.calls[in.Arg].argc)
+		case OpJumpIfFalse, OpJumpIfTrue:
+			operand = fmt.Sprintf("%04d", in.Arg)
+		case OpList:
+			operand = fmt.Sprint(in.Arg)
+		case OpBool:
+			operand = TokenKind(in.Arg).Symbol()
+		}
+		if operand == "" {
+			fmt.Fprintf(&b, "%04d %s\n", pc, in.Op)
+		} else {
+			fmt.Fprintf(&b, "%04d %-13s %s\n", pc, in.Op, operand)
+		}
+	}
+	return b.String()
+}
+
+// 编译器, 把表达式树编译成字节码, 并在编译时折叠常量子表达式
+type Compiler struct {
+	program *Program
+	names   map[string]int32
+	scopes  []map[string]int32 // 正在编译的 let 作用域, 由外向内, 变量名到槽位
+	depth   int                // 当前操作数栈的深度
+	folder  folder
+}
+
+// 把表达式编译成字节码程序
+func Compile(expr Expression) (*Program, error) {
+	c := &Compiler{
+		program: &Program{},
+		names:   make(map[string]int32),
+		folder:  make(folder),
+	}
+	if err := c.compile(expr); err != nil {
+		return nil, err
+	}
+	return c.program, nil
+}
+
+func (c *Compiler) emit(op Opcode, arg int32) int {
+	c.program.code = append(c.program.code, Instruction{Op: op, Arg: arg})
+	c.depth += c.stackEffect(op, arg)
+	c.program.stackSize = max(c.program.stackSize, c.depth)
+	return len(c.program.code) - 1
+}
+
+// 指令执行后操作数栈深度的变化, 条件跳转按不跳转计算, 跳转时的深度在之前已经达到过
+func (c *Compiler) stackEffect(op Opcode, arg int32) int {
+	switch op {
+	case OpConst, OpLoad, OpLoadLocal:
+		return 1
+	case OpDefineLocal, OpJumpIfFalse, OpJumpIfTrue:
+		return -1
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return -1
+	case OpCall:
+		return 1 - c.program.calls[arg].argc
+	case OpList:
+		return 1 - int(arg)
+	}
+	return 0
+}
+
+func (c *Compiler) constant(v Value) int32 {
+	c.program.constants = append(c.program.constants, v)
+	return int32(len(c.program.constants) - 1)
+}
+
+func (c *Compiler) name(name string) int32 {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	i := int32(len(c.program.names))
+	c.program.names = append(c.program.names, name)
+	c.names[name] = i
+	return i
+}
+
+// 由内向外查找 let 变量的槽位
+func (c *Compiler) local(name string) (int32, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if slot, ok := c.scopes[i][name]; ok {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Compiler) compile(expr Expression) error {
+	if v, ok := c.folder.fold(expr); ok {
+		c.emit(OpConst, c.constant(v))
+		return nil
+	}
+	switch e := expr.(type) {
+	case *PlusExpression:
+		return c.compileBinary(OpAdd, e.left, e.right)
+	case *MinusExpression:
+		return c.compileBinary(OpSub, e.left, e.right)
+	case *MultiplyExpression:
+		return c.compileBinary(OpMul, e.left, e.right)
+	case *DivideExpression:
+		return c.compileBinary(OpDiv, e.left, e.right)
+	case *ModuloExpression:
+		return c.compileBinary(OpMod, e.left, e.right)
+	case *CompareExpression:
+		return c.compileBinary(binaryOpcodes[e.op], e.left, e.right)
+	case *AndExpression:
+		return c.compileLogical(OpJumpIfFalse, AND, e.left, e.right)
+	case *OrExpression:
+		return c.compileLogical(OpJumpIfTrue, OR, e.left, e.right)
+	case *NegateExpression:
+		if err := c.compile(e.operand); err != nil {
+			return err
+		}
+		c.emit(OpNeg, 0)
+	case *NotExpression:
+		if err := c.compile(e.operand); err != nil {
+			return err
+		}
+		c.emit(OpNot, 0)
+	case *VariableExpression:
+		if slot, ok := c.local(e.name); ok {
+			c.emit(OpLoadLocal, slot)
+		} else {
+			c.emit(OpLoad, c.name(e.name))
+		}
+	case *AssignExpression:
+		if err := c.compile(e.value); err != nil {
+			return err
+		}
+		if slot, ok := c.local(e.name); ok {
+			c.emit(OpStoreLocal, slot)
+		} else {
+			c.emit(OpStore, c.name(e.name))
+		}
+	case *LetExpression:
+		// 每个绑定分配一个新槽位, 后面的绑定和 body 可以看到前面的绑定, 内层的同名变量遮蔽外层
+		scope := make(map[string]int32)
+		c.scopes = append(c.scopes, scope)
+		defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+		for _, b := range e.bindings {
+			if err := c.compile(b.value); err != nil {
+				return err
+			}
+			slot := int32(len(c.program.locals))
+			c.program.locals = append(c.program.locals, b.name)
+			c.emit(OpDefineLocal, slot)
+			scope[b.name] = slot
+		}
+		if err := c.compile(e.body); err != nil {
+			return err
+		}
+	case *CallExpression:
+		for _, arg := range e.args {
+			if err := c.compile(arg); err != nil {
+				return err
+			}
+		}
+		c.program.calls = append(c.program.calls, callSite{name: e.name, argc: len(e.args)})
+		c.emit(OpCall, int32(len(c.program.calls)-1))
+	case *ListExpression:
+		for _, element := range e.elements {
+			if err := c.compile(element); err != nil {
+				return err
+			}
+		}
+		c.emit(OpList, int32(len(e.elements)))
+	default:
+		return fmt.Errorf("compile: unsupported expression %T", expr)
+	}
+	return nil
+}
+
+func (c *Compiler) compileBinary(op Opcode, left, right Expression) error {
+	if err := c.compile(left); err != nil {
+		return err
+	}
+	if err := c.compile(right); err != nil {
+		return err
+	}
+	c.emit(op, 0)
+	return nil
+}
+
+// 短路求值: 左操作数决定结果时跳过右操作数, 跳转时左操作数留在栈顶作为结果
+func (c *Compiler) compileLogical(jump Opcode, op TokenKind, left, right Expression) error {
+	if err := c.compile(left); err != nil {
+		return err
+	}
+	at := c.emit(jump, 0)
+	if err := c.compile(right); err != nil {
+		return err
+	}
+	c.emit(OpBool, int32(op))
+	c.program.code[at].Arg = int32(len(c.program.code))
+	return nil
+}
+
+// 常量折叠器, 记住每个子表达式的折叠结果, 编译器和 ConstantFolding 共用。
+// 不依赖变量和函数调用的子表达式直接求值, 求值出错的子表达式不折叠, 让错误在运行时照常报告
+type folder map[Expression]folded
+
+type folded struct {
+	value Value
+	ok    bool
+}
+
+func (f folder) fold(expr Expression) (Value, bool) {
+	if r, ok := f[expr]; ok {
+		return r.value, r.ok
+	}
+	v, ok := f.evalConstant(expr)
+	if ok && !finite(v) {
+		// 无穷大和 NaN 没有字面量写法, 折叠后无法被 Format 和 MarshalExpression 表示, 留到运行时计算
+		v, ok = NilValue, false
+	}
+	f[expr] = folded{value: v, ok: ok}
+	return v, ok
+}
+
+// 值中没有无穷大和 NaN
+func finite(v Value) bool {
+	switch v.Kind() {
+	case FloatKind:
+		f := v.float()
+		return !math.IsInf(f, 0) && !math.IsNaN(f)
+	case ListKind:
+		for _, item := range v.items() {
+			if !finite(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f folder) evalConstant(expr Expression) (Value, bool) {
+	switch e := expr.(type) {
+	case *NumericExpression, *LiteralExpression:
+		v, err := e.Interpret(nil)
+		return v, err == nil
+	case *AndExpression:
+		// false and x 的结果与 x 无关
+		if left, ok := f.fold(e.left); ok && left.Kind() == BoolKind && !left.bool() {
+			return left, true
+		}
+	case *OrExpression:
+		if left, ok := f.fold(e.left); ok && left.Kind() == BoolKind && left.bool() {
+			return left, true
+		}
+	case *CallExpression, *VariableExpression, *AssignExpression, *LetExpression:
+		return NilValue, false
+	}
+	for _, child := range children(expr) {
+		if _, ok := f.fold(child); !ok {
+			return NilValue, false
+		}
+	}
+	// 子表达式都是常量, 直接用树遍历求值, 这些节点不会访问环境
+	v, err := expr.Interpret(nil)
+	return v, err == nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"time"
+)
+
+// 参数类型, 按位组合表示一个参数可以接受的多种值类型。
+// 静态分析也用它表示一个表达式可能产生的值类型的集合
+type ParamType uint8
+
+const (
+	ParamNil ParamType = 1 << iota
+	ParamInt
+	ParamFloat
+	ParamBool
+	ParamString
+	ParamList
+
+	ParamNumber = ParamInt | ParamFloat
+	ParamAny    = ParamNil | ParamInt | ParamFloat | ParamBool | ParamString | ParamList
+)
+
+// 值的类型是否满足参数类型
+func (t ParamType) Accepts(v Value) bool {
+	return t&(1<<v.Kind()) != 0
+}
+
+func (t ParamType) String() string {
+	switch t {
+	case 0:
+		return "none"
+	case ParamAny:
+		return "any"
+	}
+	var names []string
+	for k := NilKind; k <= ListKind; k++ {
+		if t&(1<<k) != 0 {
+			names = append(names, k.String())
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// 函数调用的参数个数或类型不正确
+type ArgumentError struct {
+	Function string
+	Msg      string
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Function + ": " + e.Msg
+}
+
+// 可以在表达式中调用的函数
+type Function struct {
+	Name   string
+	Params []ParamType
+	// 为 true 时最后一个参数可以出现零次或多次, 与 Go 的可变参数相同
+	Variadic bool
+	// 返回值类型, 供静态分析推断调用结果的类型, 零值表示任意类型
+	Returns ParamType
+	// 函数实现。args 只在调用期间有效, 虚拟机直接传入操作数栈上的参数, 调用返回后会复用这段存储,
+	// 需要在返回值或其他地方保留参数时先复制
+	Impl func(args []Value) (Value, error)
+}
+
+// 检查参数个数和类型后调用函数实现
+func (f *Function) Call(args []Value) (Value, error) {
+	if err := f.check(args); err != nil {
+		return NilValue, err
+	}
+	return f.Impl(args)
+}
+
+func (f *Function) check(args []Value) error {
+	if err := f.checkArity(len(args)); err != nil {
+		return err
+	}
+	for i, arg := range args {
+		param := f.param(i)
+		if !param.Accepts(arg) {
+			return &ArgumentError{Function: f.Name, Msg: fmt.Sprintf("argument %d must be %s, got %s", i+1, param, arg.Kind())}
+		}
+	}
+	return nil
+}
+
+// 检查参数个数, 静态分析也使用它
+func (f *Function) checkArity(n int) error {
+	fixed := len(f.Params)
+	if f.Variadic {
+		fixed--
+		if n < fixed {
+			return &ArgumentError{Function: f.Name, Msg: fmt.Sprintf("expected at least %d %s, got %d", fixed, plural(fixed, "argument"), n)}
+		}
+	} else if n != fixed {
+		return &ArgumentError{Function: f.Name, Msg: fmt.Sprintf("expected %d %s, got %d", fixed, plural(fixed, "argument"), n)}
+	}
+	return nil
+}
+
+// 第 i 个参数的类型, 可变参数的多余参数使用最后一个参数的类型
+func (f *Function) param(i int) ParamType {
+	return f.Params[min(i, len(f.Params)-1)]
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// 函数注册表
+type FunctionRegistry struct {
+	functions map[string]*Function
+}
+
+// 创建一个包含内置函数的注册表
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{functions: make(map[string]*Function)}
+	for _, fn := range builtins() {
+		r.functions[fn.Name] = fn
+	}
+	return r
+}
+
+// 注册函数, 同名函数会被替换, 因此也可以用来覆盖内置函数
+func (r *FunctionRegistry) Register(fn *Function) error {
+	if !isIdentifier(fn.Name) {
+		return fmt.Errorf("invalid function name %q", fn.Name)
+	}
+	if fn.Impl == nil {
+		return fmt.Errorf("function %q has no implementation", fn.Name)
+	}
+	if fn.Variadic && len(fn.Params) == 0 {
+		return fmt.Errorf("variadic function %q must declare at least one parameter", fn.Name)
+	}
+	if r.functions == nil {
+		r.functions = make(map[string]*Function)
+	}
+	r.functions[fn.Name] = fn
+	return nil
+}
+
+func (r *FunctionRegistry) Unregister(name string) {
+	delete(r.functions, name)
+}
+
+// 查找函数, nil 注册表中没有任何函数
+func (r *FunctionRegistry) Lookup(name string) (*Function, bool) {
+	if r == nil {
+		return nil, false
+	}
+	fn, ok := r.functions[name]
+	return fn, ok
+}
+
+// 复制一份注册表, 修改副本不会影响原注册表
+func (r *FunctionRegistry) Clone() *FunctionRegistry {
+	return &FunctionRegistry{functions: maps.Clone(r.functions)}
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !isIdentPart(r) || i == 0 && !isIdentStart(r) {
+			return false
+		}
+	}
+	_, keyword := keywords[name]
+	return name != "" && !keyword
+}
+
+// 内置函数
+func builtins() []*Function {
+	return []*Function{
+		{Name: "min", Params: []ParamType{ParamNumber | ParamString, ParamNumber | ParamString}, Variadic: true, Returns: ParamNumber | ParamString, Impl: extremum(-1)},
+		{Name: "max", Params: []ParamType{ParamNumber | ParamString, ParamNumber | ParamString}, Variadic: true, Returns: ParamNumber | ParamString, Impl: extremum(1)},
+		{Name: "abs", Params: []ParamType{ParamNumber}, Returns: ParamNumber, Impl: func(args []Value) (Value, error) {
+			if i, ok := args[0].AsInt(); ok {
+				if i == math.MinInt64 {
+					return NilValue, &ArgumentError{Function: "abs", Msg: "integer overflow"}
+				}
+				return IntValue(max(i, -i)), nil
+			}
+			f, _ := args[0].AsFloat()
+			return FloatValue(math.Abs(f)), nil
+		}},
+		{Name: "len", Params: []ParamType{ParamString | ParamList}, Returns: ParamInt, Impl: func(args []Value) (Value, error) {
+			if list, ok := args[0].AsList(); ok {
+				return IntValue(int64(len(list))), nil
+			}
+			s, _ := args[0].AsString()
+			return IntValue(int64(len([]rune(s)))), nil
+		}},
+		{Name: "contains", Params: []ParamType{ParamString | ParamList, ParamAny}, Returns: ParamBool, Impl: func(args []Value) (Value, error) {
+			if list, ok := args[0].AsList(); ok {
+				for _, item := range list {
+					if Equal(item, args[1]) {
+						return BoolValue(true), nil
+					}
+				}
+				return BoolValue(false), nil
+			}
+			s, _ := args[0].AsString()
+			sub, ok := args[1].AsString()
+			if !ok {
+				return NilValue, &ArgumentError{Function: "contains", Msg: fmt.Sprintf("argument 2 must be string, got %s", args[1].Kind())}
+			}
+			return BoolValue(strings.Contains(s, sub)), nil
+		}},
+		{Name: "upper", Params: []ParamType{ParamString}, Returns: ParamString, Impl: func(args []Value) (Value, error) {
+			s, _ := args[0].AsString()
+			return StringValue(strings.ToUpper(s)), nil
+		}},
+		{Name: "lower", Params: []ParamType{ParamString}, Returns: ParamString, Impl: func(args []Value) (Value, error) {
+			s, _ := args[0].AsString()
+			return StringValue(strings.ToLower(s)), nil
+		}},
+		// 当前 Unix 时间, 单位秒
+		{Name: "now", Returns: ParamInt, Impl: func(args []Value) (Value, error) {
+			return IntValue(time.Now().Unix()), nil
+		}},
+	}
+}
+
+// 返回 min(sign < 0) 或 max(sign > 0) 的实现
+func extremum(sign int) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		result := args[0]
+		for _, v := range args[1:] {
+			c, err := Compare(v, result)
+			if err != nil {
+				return NilValue, err
+			}
+			if c*sign > 0 {
+				result = v
+			}
+		}
+		return result, nil
+	}
+}
@@ -1,33 +1,353 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
 
-// 抽象表达式
+// 抽象表达式, 求值结果为带类型的值, 运行时错误(类型不匹配、除以零等)通过 error 返回
 type Expression interface {
-	Interpret(context *Context) int
+	Interpret(context *Context) (Value, error)
 }
 
-// 非终结符表达式
+// 依次求值左右操作数, 再执行算术运算
+func interpretArithmetic(op TokenKind, left, right Expression, context *Context) (Value, error) {
+	a, err := left.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	b, err := right.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	return arithmetic(op, a, b)
+}
+
+// 非终结符表达式, 数值相加, 或者连接两个字符串、两个列表
 type PlusExpression struct {
 	left, right Expression
 }
 
-func (p *PlusExpression) Interpret(context *Context) int {
-	return p.left.Interpret(context) + p.right.Interpret(context)
+func (p *PlusExpression) Interpret(context *Context) (Value, error) {
+	return interpretArithmetic(PLUS, p.left, p.right, context)
+}
+
+type MinusExpression struct {
+	left, right Expression
+}
+
+func (m *MinusExpression) Interpret(context *Context) (Value, error) {
+	return interpretArithmetic(MINUS, m.left, m.right, context)
+}
+
+type MultiplyExpression struct {
+	left, right Expression
+}
+
+func (m *MultiplyExpression) Interpret(context *Context) (Value, error) {
+	return interpretArithmetic(STAR, m.left, m.right, context)
+}
+
+// 除法, 两个整数相除结果为整数, 除数为 0 时返回 ErrDivisionByZero
+type DivideExpression struct {
+	left, right Expression
+}
+
+func (d *DivideExpression) Interpret(context *Context) (Value, error) {
+	return interpretArithmetic(SLASH, d.left, d.right, context)
+}
+
+// 取模, 只支持整数
+type ModuloExpression struct {
+	left, right Expression
+}
+
+func (m *ModuloExpression) Interpret(context *Context) (Value, error) {
+	return interpretArithmetic(PERCENT, m.left, m.right, context)
+}
+
+// 比较运算: == != < <= > >=
+type CompareExpression struct {
+	op          TokenKind
+	left, right Expression
+}
+
+func (c *CompareExpression) Interpret(context *Context) (Value, error) {
+	a, err := c.left.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	b, err := c.right.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	return relational(c.op, a, b)
+}
+
+// 逻辑与, 左操作数为 false 时不再求值右操作数
+type AndExpression struct {
+	left, right Expression
+}
+
+func (a *AndExpression) Interpret(context *Context) (Value, error) {
+	return interpretLogical(AND, a.left, a.right, context)
 }
 
-// 终结符表达式
+// 逻辑或, 左操作数为 true 时不再求值右操作数
+type OrExpression struct {
+	left, right Expression
+}
+
+func (o *OrExpression) Interpret(context *Context) (Value, error) {
+	return interpretLogical(OR, o.left, o.right, context)
+}
+
+func interpretLogical(op TokenKind, left, right Expression, context *Context) (Value, error) {
+	a, err := left.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	x, err := truth(a, op)
+	if err != nil {
+		return NilValue, err
+	}
+	// 短路求值
+	if x == (op == OR) {
+		return BoolValue(x), nil
+	}
+	b, err := right.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	y, err := truth(b, op)
+	if err != nil {
+		return NilValue, err
+	}
+	return BoolValue(y), nil
+}
+
+// 根据运算符创建对应的二元表达式
+func newBinary(op TokenKind, left, right Expression) Expression {
+	switch op {
+	case PLUS:
+		return &PlusExpression{left: left, right: right}
+	case MINUS:
+		return &MinusExpression{left: left, right: right}
+	case STAR:
+		return &MultiplyExpression{left: left, right: right}
+	case SLASH:
+		return &DivideExpression{left: left, right: right}
+	case PERCENT:
+		return &ModuloExpression{left: left, right: right}
+	case AND:
+		return &AndExpression{left: left, right: right}
+	case OR:
+		return &OrExpression{left: left, right: right}
+	}
+	return &CompareExpression{op: op, left: left, right: right}
+}
+
+// 一元负号
+type NegateExpression struct {
+	operand Expression
+}
+
+func (n *NegateExpression) Interpret(context *Context) (Value, error) {
+	v, err := n.operand.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	switch v.Kind() {
+	case IntKind:
+		return IntValue(-v.int()), nil
+	case FloatKind:
+		return FloatValue(-v.float()), nil
+	}
+	return NilValue, &TypeError{Msg: fmt.Sprintf("invalid operation: -%s", v.Kind())}
+}
+
+// 逻辑非
+type NotExpression struct {
+	operand Expression
+}
+
+func (n *NotExpression) Interpret(context *Context) (Value, error) {
+	v, err := n.operand.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	b, err := truth(v, NOT)
+	if err != nil {
+		return NilValue, err
+	}
+	return BoolValue(!b), nil
+}
+
+// 函数调用, 函数从环境的函数注册表中查找, 调用前检查参数个数和类型
+type CallExpression struct {
+	name string
+	args []Expression
+}
+
+func (c *CallExpression) Interpret(context *Context) (Value, error) {
+	fn, ok := context.Functions().Lookup(c.name)
+	if !ok {
+		return NilValue, &UndefinedFunctionError{Name: c.name}
+	}
+	args := make([]Value, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.Interpret(context)
+		if err != nil {
+			return NilValue, err
+		}
+		args[i] = v
+	}
+	return fn.Call(args)
+}
+
+// 列表字面量
+type ListExpression struct {
+	elements []Expression
+}
+
+func (l *ListExpression) Interpret(context *Context) (Value, error) {
+	items := make([]Value, len(l.elements))
+	for i, element := range l.elements {
+		v, err := element.Interpret(context)
+		if err != nil {
+			return NilValue, err
+		}
+		items[i] = v
+	}
+	return ListValue(items...), nil
+}
+
+// 终结符表达式, 整数
 type NumericExpression struct {
-	value int
+	value int64
 }
 
-func (n *NumericExpression) Interpret(context *Context) int {
-	return n.value
+func (n *NumericExpression) Interpret(context *Context) (Value, error) {
+	return IntValue(n.value), nil
+}
+
+// 其他字面量: 浮点数、字符串、布尔值和 nil
+type LiteralExpression struct {
+	value Value
+}
+
+func (l *LiteralExpression) Interpret(context *Context) (Value, error) {
+	return l.value, nil
+}
+
+// 变量, 值从当前作用域开始向外查找, 未定义时返回 *UndefinedVariableError
+type VariableExpression struct {
+	name string
+}
+
+func (v *VariableExpression) Interpret(context *Context) (Value, error) {
+	value, ok := context.scope.Lookup(v.name)
+	if !ok {
+		return NilValue, &UndefinedVariableError{Name: v.name}
+	}
+	return value, nil
 }
 
-// 环境角色
+// 赋值, 修改最近一层定义了该变量的作用域, 结果为所赋的值
+type AssignExpression struct {
+	name  string
+	value Expression
+}
+
+func (a *AssignExpression) Interpret(context *Context) (Value, error) {
+	value, err := a.value.Interpret(context)
+	if err != nil {
+		return NilValue, err
+	}
+	if !context.scope.Assign(a.name, value) {
+		return NilValue, &UndefinedVariableError{Name: a.name}
+	}
+	return value, nil
+}
+
+type binding struct {
+	name  string
+	value Expression
+}
+
+// let x = 1, y = x + 1 in x + y
+// 在新的作用域中依次定义变量, 后面的绑定可以引用前面的变量, 求值完 body 后作用域结束
+type LetExpression struct {
+	bindings []binding
+	body     Expression
+}
+
+func (l *LetExpression) Interpret(context *Context) (Value, error) {
+	context.PushScope()
+	defer context.PopScope()
+	for _, b := range l.bindings {
+		value, err := b.value.Interpret(context)
+		if err != nil {
+			return NilValue, err
+		}
+		context.current().Define(b.name, value)
+	}
+	return l.body.Interpret(context)
+}
+
+// 环境角色, 保存变量作用域链和函数注册表。
+// 零值可以直接使用, 第一次用到时创建全局作用域和包含内置函数的注册表
 type Context struct {
-	// 可以添加一些全局信息
+	scope     *Scope
+	functions *FunctionRegistry
+}
+
+// 创建环境, 函数注册表中预置了内置函数
+func NewContext() *Context {
+	return &Context{
+		scope:     NewScope(nil),
+		functions: NewFunctionRegistry(),
+	}
+}
+
+// 在当前作用域定义变量
+func (c *Context) SetVariable(name string, value Value) {
+	c.current().Define(name, value)
+}
+
+// 当前作用域, 零值的环境在这里创建全局作用域
+func (c *Context) current() *Scope {
+	if c.scope == nil {
+		c.scope = NewScope(nil)
+	}
+	return c.scope
+}
+
+// 从当前作用域开始向外查找变量
+func (c *Context) Variable(name string) (Value, bool) {
+	return c.scope.Lookup(name)
+}
+
+// 进入一个新的嵌套作用域
+func (c *Context) PushScope() {
+	c.scope = NewScope(c.current())
+}
+
+// 离开当前作用域, 不会弹出最外层的全局作用域
+func (c *Context) PopScope() {
+	if c.scope != nil && c.scope.parent != nil {
+		c.scope = c.scope.parent
+	}
+}
+
+// 环境的函数注册表, 可以在运行时注册自定义函数
+func (c *Context) Functions() *FunctionRegistry {
+	if c.functions == nil {
+		c.functions = NewFunctionRegistry()
+	}
+	return c.functions
 }
 
 func main() {
@@ -40,7 +360,158 @@ func main() {
 		},
 	}
 
-	context := &Context{}
-	result := expression.Interpret(context)
+	context := NewContext()
+	result, _ := expression.Interpret(context)
 	fmt.Println("Result:", result) // 输出: Result: 10
+
+	// 从字符串解析出抽象语法树
+	context.SetVariable("a", IntValue(1))
+	context.SetVariable("b", IntValue(5))
+	context.SetVariable("c", IntValue(8))
+	for _, src := range []string{
+		"(a + 3) * max(b, 2) - c / 4",
+		"2 - 3 - 4",
+		"-2 * -(3 + 1) % 5",
+		"2 + 3 * 4",
+		"7 / 2.0",
+	} {
+		parsed, err := Parse(src)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		result, _ := parsed.Interpret(context)
+		fmt.Println(src, "=", result)
+	}
+	// 输出:
+	// (a + 3) * max(b, 2) - c / 4 = 18
+	// 2 - 3 - 4 = -5
+	// -2 * -(3 + 1) % 5 = 3
+	// 2 + 3 * 4 = 14
+	// 7 / 2.0 = 3.5
+
+	// 变量作用域和赋值
+	parsed, _ := Parse("let a = 10, d = a * 2 in b = a + d")
+	result, _ = parsed.Interpret(context)
+	fmt.Println(result) // 输出: 30
+	value, _ := context.Variable("a")
+	fmt.Println(value) // 输出: 1
+	value, _ = context.Variable("b")
+	fmt.Println(value) // 输出: 30
+
+	// 特性开关和路由规则
+	context.SetVariable("region", StringValue("eu"))
+	context.SetVariable("beta", BoolValue(false))
+	context.SetVariable("version", FloatValue(2.1))
+	rule, _ := Parse(`(region == "eu" or region == "us") and (beta or version >= 2.0) && not (region + "-west" == "eu-east")`)
+	result, _ = rule.Interpret(context)
+	fmt.Println(result) // 输出: true
+
+	// 短路求值: 右边的未定义变量不会被求值
+	rule, _ = Parse("beta and undefined > 1")
+	result, err := rule.Interpret(context)
+	fmt.Println(result, err) // 输出: false <nil>
+
+	for _, src := range []string{"d + 1", "10 / (a - 1)", `"n" + 1`, "1 < [1]"} {
+		parsed, _ := Parse(src)
+		_, err := parsed.Interpret(context)
+		fmt.Println(err, errors.Is(err, ErrDivisionByZero))
+	}
+	// 输出:
+	// undefined variable "d" false
+	// division by zero true
+	// type error: invalid operation: string + int false
+	// type error: cannot compare int and list false
+
+	// 内置函数和自定义函数
+	context.Functions().Register(&Function{
+		Name:     "join",
+		Params:   []ParamType{ParamString, ParamString},
+		Variadic: true,
+		Impl: func(args []Value) (Value, error) {
+			sep, _ := args[0].AsString()
+			parts := make([]string, len(args)-1)
+			for i, arg := range args[1:] {
+				parts[i], _ = arg.AsString()
+			}
+			return StringValue(strings.Join(parts, sep)), nil
+		},
+	})
+	for _, src := range []string{
+		`upper(join("-", region, "west")) == "EU-WEST"`,
+		`min(abs(-3), 2.5, len([1, 2, 3])) + len("héllo")`,
+		`contains(["eu", "us"], region) and now() > 0`,
+		`abs()`,
+		`abs("x")`,
+		`upper(region, 1)`,
+		`join()`,
+	} {
+		parsed, _ := Parse(src)
+		result, err := parsed.Interpret(context)
+		fmt.Println(result, err)
+	}
+	// 输出:
+	// true <nil>
+	// 7.5 <nil>
+	// true <nil>
+	// nil abs: expected 1 argument, got 0
+	// nil abs: argument 1 must be int or float, got string
+	// nil upper: expected 1 argument, got 2
+	// nil join: expected at least 1 argument, got 0
+
+	// 编译成字节码后在虚拟机上执行, 常量子表达式在编译时已被折叠
+	rule, _ = Parse(`a * (60 * 60) > 3000 and contains(["eu", "us"], region)`)
+	program, _ := Compile(rule)
+	fmt.Print(program)
+	result, _ = program.Run(context)
+	fmt.Println(result)
+	// 输出:
+	// 0000 LOAD          a
+	// 0001 CONST         3600
+	// 0002 MUL
+	// 0003 CONST         3000
+	// 0004 GT
+	// 0005 JUMP_IF_FALSE 0010
+	// 0006 CONST         ["eu", "us"]
+	// 0007 LOAD          region
+	// 0008 CALL          contains/2
+	// 0009 BOOL          and
+	// true
+
+	// 规范格式输出和 JSON 序列化, 规则可以保存在配置文件中
+	rule, _ = Parse(`((a + (3))) * max(b, 2) - (c / 4) > 10 and not (region == "eu")`)
+	fmt.Println(Format(rule))
+	// 输出: (a + 3) * max(b, 2) - c / 4 > 10 and not (region == "eu")
+	data, _ := MarshalExpression(&MinusExpression{left: &VariableExpression{name: "a"}, right: &NumericExpression{1}})
+	fmt.Println(string(data))
+	// 输出: {"type":"binary","op":"-","left":{"type":"variable","name":"a"},"right":{"type":"int","value":1}}
+
+	var config struct {
+		Name string         `json:"name"`
+		Rule JSONExpression `json:"rule"`
+	}
+	config.Name = "eu-beta"
+	config.Rule.Expression = rule
+	data, _ = json.Marshal(config)
+	config.Rule.Expression = nil
+	json.Unmarshal(data, &config)
+	fmt.Println(config.Name, Format(config.Rule.Expression)) // 输出: eu-beta (a + 3) * max(b, 2) - c / 4 > 10 and not (region == "eu")
+
+	// 部署规则前做静态分析: 读取了哪些变量、结果类型、可能的错误, 以及优化后的表达式
+	unit, _ := Analyze("let limit = 60 * 60, unused = 0 in\n  true and a / (3600 - 60 * 60) > limit or region - 1 == 0", map[string]ParamType{
+		"a":      ParamInt,
+		"region": ParamString,
+	})
+	fmt.Println(unit.FreeVariables, unit.Type, Format(unit.Expr))
+	for _, d := range unit.Diagnostics {
+		fmt.Println(d)
+	}
+	// 输出:
+	// [a region] bool let limit = 3600 in a / 0 > limit or region - 1 == 0
+	// 1:31: warning: variable "unused" is never used (dead-branches)
+	// 2:14: error: division by constant zero (zero-division)
+	// 2:51: error: invalid operation: string - int (types)
+
+	_, err = Parse("1 +\n  (2 * )")
+	fmt.Println(err) // 输出: syntax error at line 2, column 8: unexpected ')'
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 对比树遍历求值和字节码虚拟机的性能, 运行方式: go test -bench . *.go
+//
+// 虚拟机的收益来自纯运算和 let 变量: 常量在编译时折叠, let 变量在编译时分配槽位, 函数参数直接在栈上传递。
+// 环境中的变量仍然按名字在作用域链上查找, 以它们为主的规则表达式(Rule)两种方式耗时相当
+
+// 基准测试和对比测试共用的环境
+func benchmarkContext() *Context {
+	context := NewContext()
+	context.SetVariable("x", IntValue(0))
+	context.SetVariable("a", IntValue(1))
+	context.SetVariable("b", IntValue(5))
+	context.SetVariable("c", IntValue(8))
+	context.SetVariable("region", StringValue("eu"))
+	return context
+}
+
+// 深度为 64 的 PlusExpression 链: 1 + (1 + (1 + ...)), 以纯运算为主
+func plusChain() Expression {
+	var chain Expression = &VariableExpression{name: "x"}
+	for range 64 {
+		chain = &PlusExpression{left: &NumericExpression{1}, right: chain}
+	}
+	return chain
+}
+
+// 以变量查找和函数调用为主的规则表达式
+func ruleExpression(b *testing.B) Expression {
+	rule, err := Parse(`(a + 3) * max(b, 2) - c / 4 > 10 and region == "eu" or 2 * 60 * 60 < a`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return rule
+}
+
+// let 变量被多次使用的表达式
+func letExpression(b *testing.B) Expression {
+	expr, err := Parse(`let t = a * 60, limit = 3600 in let low = limit / 2 in t > low and t < limit or t == limit * 2`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return expr
+}
+
+func benchmarkInterpret(b *testing.B, expr Expression) {
+	context := benchmarkContext()
+	for b.Loop() {
+		if _, err := expr.Interpret(context); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkVM(b *testing.B, expr Expression) {
+	program, err := Compile(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	context := benchmarkContext()
+	for b.Loop() {
+		if _, err := program.Run(context); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInterpretPlusChain(b *testing.B) { benchmarkInterpret(b, plusChain()) }
+func BenchmarkVMPlusChain(b *testing.B)        { benchmarkVM(b, plusChain()) }
+func BenchmarkInterpretRule(b *testing.B)      { benchmarkInterpret(b, ruleExpression(b)) }
+func BenchmarkVMRule(b *testing.B)             { benchmarkVM(b, ruleExpression(b)) }
+func BenchmarkInterpretLet(b *testing.B)       { benchmarkInterpret(b, letExpression(b)) }
+func BenchmarkVMLet(b *testing.B)              { benchmarkVM(b, letExpression(b)) }
+
+// 虚拟机的结果和错误与树遍历求值一致, 包括 let 变量的遮蔽和赋值
+func TestVMMatchesInterpret(t *testing.T) {
+	for _, src := range []string{
+		"(a + 3) * max(b, 2) - c / 4",
+		"let a = 10, d = a * 2 in b = a + d",
+		"let a = a + 1, a = a * 2 in a",
+		"let x = 1 in let x = x + 1 in x = x * 10",
+		"let x = 1 in (let x = 5 in x) + x",
+		"let x = b = 7 in x + b",
+		"let x = 1 in d + x",
+		"let x = 0 in 1 / x",
+		"a > 0 or undefined",
+		"[a, let y = 2 in y, max(c, 9)]",
+	} {
+		expr, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		interpreted, vm := benchmarkContext(), benchmarkContext()
+		want, wantErr := expr.Interpret(interpreted)
+		program, err := Compile(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, gotErr := program.Run(vm)
+		if fmt.Sprint(got, gotErr) != fmt.Sprint(want, wantErr) {
+			t.Errorf("%s: vm = %v, %v; interpret = %v, %v", src, got, gotErr, want, wantErr)
+		}
+		for _, name := range []string{"a", "b", "d", "x"} {
+			w, _ := interpreted.Variable(name)
+			g, _ := vm.Variable(name)
+			if w != g {
+				t.Errorf("%s: variable %s = %v after vm, %v after interpret", src, name, g, w)
+			}
+		}
+	}
+}
+
+// 零值的 Context 可以直接使用, 函数注册表中有内置函数
+func TestZeroContext(t *testing.T) {
+	expr, err := Parse("let y = 3 in max(x, y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := Compile(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, run := range map[string]func(*Context) (Value, error){
+		"interpret": expr.Interpret,
+		"vm":        program.Run,
+	} {
+		context := &Context{}
+		context.SetVariable("x", IntValue(5))
+		got, err := run(context)
+		if err != nil || got != IntValue(5) {
+			t.Errorf("%s: got %v, %v; want 5", name, got, err)
+		}
+		if _, ok := context.Variable("y"); ok {
+			t.Errorf("%s: let variable y leaked into the global scope", name)
+		}
+	}
+	if _, err := expr.Interpret(&Context{}); err == nil {
+		t.Error("interpret with empty context: want undefined variable error")
+	}
+	var registry *FunctionRegistry
+	if _, ok := registry.Lookup("max"); ok {
+		t.Error("nil registry: Lookup found max")
+	}
+}
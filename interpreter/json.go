@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 表达式树的 JSON 编码, 每个节点是一个带 "type" 字段的对象:
+//
+//	{"type": "binary", "op": "+", "left": {...}, "right": {...}}
+//	{"type": "unary", "op": "not", "operand": {...}}
+//	{"type": "int", "value": 1}    {"type": "float", "value": 1.5}
+//	{"type": "string", "value": "eu"}    {"type": "bool", "value": true}    {"type": "nil"}
+//	{"type": "list", "elements": [...]}
+//	{"type": "variable", "name": "a"}
+//	{"type": "assign", "name": "a", "value": {...}}
+//	{"type": "let", "bindings": [{"name": "a", "value": {...}}], "body": {...}}
+//	{"type": "call", "name": "max", "args": [...]}
+type jsonNode struct {
+	Type     string          `json:"type"`
+	Op       string          `json:"op,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Left     *jsonNode       `json:"left,omitempty"`
+	Right    *jsonNode       `json:"right,omitempty"`
+	Operand  *jsonNode       `json:"operand,omitempty"`
+	Elements []*jsonNode     `json:"elements,omitempty"`
+	Args     []*jsonNode     `json:"args,omitempty"`
+	Bindings []jsonBinding   `json:"bindings,omitempty"`
+	Body     *jsonNode       `json:"body,omitempty"`
+}
+
+type jsonBinding struct {
+	Name  string    `json:"name"`
+	Value *jsonNode `json:"value"`
+}
+
+// 可以嵌入配置结构体中的表达式, 序列化为上面的 JSON 格式
+type JSONExpression struct {
+	Expression
+}
+
+func (e JSONExpression) MarshalJSON() ([]byte, error) {
+	return MarshalExpression(e.Expression)
+}
+
+func (e *JSONExpression) UnmarshalJSON(data []byte) error {
+	expr, err := UnmarshalExpression(data)
+	if err != nil {
+		return err
+	}
+	e.Expression = expr
+	return nil
+}
+
+// 把表达式编码为 JSON
+func MarshalExpression(expr Expression) ([]byte, error) {
+	node, err := encodeNode(expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+// 从 JSON 解码出表达式
+func UnmarshalExpression(data []byte) (Expression, error) {
+	var node jsonNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	return decodeNode(&node)
+}
+
+func encodeNode(expr Expression) (*jsonNode, error) {
+	switch e := expr.(type) {
+	case *NumericExpression:
+		return encodeValue(IntValue(e.value))
+	case *LiteralExpression:
+		return encodeValue(e.value)
+	case *VariableExpression:
+		return &jsonNode{Type: "variable", Name: e.name}, nil
+	case *NegateExpression:
+		return encodeUnary(MINUS, e.operand)
+	case *NotExpression:
+		return encodeUnary(NOT, e.operand)
+	case *ListExpression:
+		elements, err := encodeNodes(e.elements)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "list", Elements: elements}, nil
+	case *CallExpression:
+		args, err := encodeNodes(e.args)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "call", Name: e.name, Args: args}, nil
+	case *AssignExpression:
+		value, err := encodeNode(e.value)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "assign", Name: e.name, Value: raw}, nil
+	case *LetExpression:
+		node := &jsonNode{Type: "let"}
+		for _, b := range e.bindings {
+			value, err := encodeNode(b.value)
+			if err != nil {
+				return nil, err
+			}
+			node.Bindings = append(node.Bindings, jsonBinding{Name: b.name, Value: value})
+		}
+		body, err := encodeNode(e.body)
+		if err != nil {
+			return nil, err
+		}
+		node.Body = body
+		return node, nil
+	}
+	op, left, right, ok := binaryParts(expr)
+	if !ok {
+		return nil, fmt.Errorf("encode expression: unsupported expression %T", expr)
+	}
+	l, err := encodeNode(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := encodeNode(right)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonNode{Type: "binary", Op: op.Symbol(), Left: l, Right: r}, nil
+}
+
+func encodeNodes(exprs []Expression) ([]*jsonNode, error) {
+	nodes := make([]*jsonNode, len(exprs))
+	for i, expr := range exprs {
+		node, err := encodeNode(expr)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = node
+	}
+	return nodes, nil
+}
+
+func encodeUnary(op TokenKind, operand Expression) (*jsonNode, error) {
+	node, err := encodeNode(operand)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonNode{Type: "unary", Op: op.Symbol(), Operand: node}, nil
+}
+
+func encodeValue(v Value) (*jsonNode, error) {
+	var raw any
+	switch v.Kind() {
+	case NilKind:
+		return &jsonNode{Type: "nil"}, nil
+	case IntKind:
+		raw = v.int()
+	case FloatKind:
+		raw = v.float()
+	case BoolKind:
+		raw = v.bool()
+	case StringKind:
+		raw = v.str()
+	case ListKind:
+		// 列表常量编码为元素都是常量的列表表达式
+		node := &jsonNode{Type: "list", Elements: []*jsonNode{}}
+		for _, item := range v.items() {
+			element, err := encodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Elements = append(node.Elements, element)
+		}
+		return node, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encode expression: %w", err)
+	}
+	return &jsonNode{Type: v.Kind().String(), Value: data}, nil
+}
+
+// 二元和一元运算符的书写形式到词法单元类型的映射
+var (
+	binaryOperators = map[string]TokenKind{}
+	unaryOperators  = map[string]TokenKind{MINUS.Symbol(): MINUS, NOT.Symbol(): NOT}
+)
+
+func init() {
+	for _, op := range []TokenKind{PLUS, MINUS, STAR, SLASH, PERCENT, EQ, NE, LT, LE, GT, GE, AND, OR} {
+		binaryOperators[op.Symbol()] = op
+	}
+}
+
+func decodeNode(node *jsonNode) (Expression, error) {
+	if node == nil {
+		return nil, fmt.Errorf("decode expression: missing node")
+	}
+	switch node.Type {
+	case "int":
+		var i int64
+		if err := decodeValue(node, &i); err != nil {
+			return nil, err
+		}
+		return &NumericExpression{i}, nil
+	case "float":
+		var f float64
+		if err := decodeValue(node, &f); err != nil {
+			return nil, err
+		}
+		return &LiteralExpression{value: FloatValue(f)}, nil
+	case "string":
+		var s string
+		if err := decodeValue(node, &s); err != nil {
+			return nil, err
+		}
+		return &LiteralExpression{value: StringValue(s)}, nil
+	case "bool":
+		var b bool
+		if err := decodeValue(node, &b); err != nil {
+			return nil, err
+		}
+		return &LiteralExpression{value: BoolValue(b)}, nil
+	case "nil":
+		return &LiteralExpression{value: NilValue}, nil
+	case "variable":
+		if !isIdentifier(node.Name) {
+			return nil, fmt.Errorf("decode expression: invalid variable name %q", node.Name)
+		}
+		return &VariableExpression{name: node.Name}, nil
+	case "list":
+		elements, err := decodeNodes(node.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &ListExpression{elements: elements}, nil
+	case "call":
+		if !isIdentifier(node.Name) {
+			return nil, fmt.Errorf("decode expression: invalid function name %q", node.Name)
+		}
+		args, err := decodeNodes(node.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &CallExpression{name: node.Name, args: args}, nil
+	case "assign":
+		if !isIdentifier(node.Name) {
+			return nil, fmt.Errorf("decode expression: invalid variable name %q", node.Name)
+		}
+		var value jsonNode
+		if err := decodeValue(node, &value); err != nil {
+			return nil, err
+		}
+		expr, err := decodeNode(&value)
+		if err != nil {
+			return nil, err
+		}
+		return &AssignExpression{name: node.Name, value: expr}, nil
+	case "let":
+		if len(node.Bindings) == 0 {
+			return nil, fmt.Errorf("decode expression: let without bindings")
+		}
+		let := &LetExpression{}
+		for _, b := range node.Bindings {
+			if !isIdentifier(b.Name) {
+				return nil, fmt.Errorf("decode expression: invalid variable name %q", b.Name)
+			}
+			value, err := decodeNode(b.Value)
+			if err != nil {
+				return nil, err
+			}
+			let.bindings = append(let.bindings, binding{name: b.Name, value: value})
+		}
+		body, err := decodeNode(node.Body)
+		if err != nil {
+			return nil, err
+		}
+		let.body = body
+		return let, nil
+	case "unary":
+		op, ok := unaryOperators[node.Op]
+		if !ok {
+			return nil, fmt.Errorf("decode expression: unknown unary operator %q", node.Op)
+		}
+		operand, err := decodeNode(node.Operand)
+		if err != nil {
+			return nil, err
+		}
+		if op == MINUS {
+			return &NegateExpression{operand: operand}, nil
+		}
+		return &NotExpression{operand: operand}, nil
+	case "binary":
+		op, ok := binaryOperators[node.Op]
+		if !ok {
+			return nil, fmt.Errorf("decode expression: unknown binary operator %q", node.Op)
+		}
+		left, err := decodeNode(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := decodeNode(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return newBinary(op, left, right), nil
+	}
+	return nil, fmt.Errorf("decode expression: unknown node type %q", node.Type)
+}
+
+func decodeNodes(nodes []*jsonNode) ([]Expression, error) {
+	exprs := make([]Expression, len(nodes))
+	for i, node := range nodes {
+		expr, err := decodeNode(node)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = expr
+	}
+	return exprs, nil
+}
+
+func decodeValue(node *jsonNode, v any) error {
+	if len(node.Value) == 0 {
+		return fmt.Errorf("decode expression: %s node without value", node.Type)
+	}
+	if err := json.Unmarshal(node.Value, v); err != nil {
+		return fmt.Errorf("decode expression: invalid %s value: %w", node.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 源码中的位置, 行号和列号都从 1 开始, 列号按字符计算
+type Position struct {
+	Line, Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// 语法错误, 携带出错位置
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+// 词法单元类型
+type TokenKind int
+
+const (
+	EOF TokenKind = iota
+	NUMBER
+	STRING
+	IDENT
+	PLUS     // +
+	MINUS    // -
+	STAR     // *
+	SLASH    // /
+	PERCENT  // %
+	LPAREN   // (
+	RPAREN   // )
+	LBRACKET // [
+	RBRACKET // ]
+	COMMA    // ,
+	ASSIGN   // =
+	EQ       // ==
+	NE       // !=
+	LT       // <
+	LE       // <=
+	GT       // >
+	GE       // >=
+	AND      // and, &&
+	OR       // or, ||
+	NOT      // not, !
+	LET      // let
+	IN       // in
+	TRUE     // true
+	FALSE    // false
+	NIL      // nil
+)
+
+var tokenNames = map[TokenKind]string{
+	EOF:    "end of input",
+	NUMBER: "number",
+	STRING: "string",
+	IDENT:  "identifier",
+}
+
+// 运算符和关键字的书写形式, and/or/not 以关键字形式为准
+var tokenSymbols = map[TokenKind]string{
+	PLUS:     "+",
+	MINUS:    "-",
+	STAR:     "*",
+	SLASH:    "/",
+	PERCENT:  "%",
+	LPAREN:   "(",
+	RPAREN:   ")",
+	LBRACKET: "[",
+	RBRACKET: "]",
+	COMMA:    ",",
+	ASSIGN:   "=",
+	EQ:       "==",
+	NE:       "!=",
+	LT:       "<",
+	LE:       "<=",
+	GT:       ">",
+	GE:       ">=",
+	AND:      "and",
+	OR:       "or",
+	NOT:      "not",
+	LET:      "let",
+	IN:       "in",
+	TRUE:     "true",
+	FALSE:    "false",
+	NIL:      "nil",
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	if symbol, ok := tokenSymbols[k]; ok {
+		return "'" + symbol + "'"
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+// 运算符或关键字的书写形式
+func (k TokenKind) Symbol() string {
+	return tokenSymbols[k]
+}
+
+// 运算符, 较长的运算符排在前面以便优先匹配
+var operators = []struct {
+	text string
+	kind TokenKind
+}{
+	{"==", EQ}, {"!=", NE}, {"<=", LE}, {">=", GE}, {"&&", AND}, {"||", OR},
+	{"+", PLUS}, {"-", MINUS}, {"*", STAR}, {"/", SLASH}, {"%", PERCENT},
+	{"(", LPAREN}, {")", RPAREN}, {"[", LBRACKET}, {"]", RBRACKET}, {",", COMMA},
+	{"=", ASSIGN}, {"<", LT}, {">", GT}, {"!", NOT},
+}
+
+// 关键字, 不能用作变量名
+var keywords = map[string]TokenKind{
+	"let":   LET,
+	"in":    IN,
+	"and":   AND,
+	"or":    OR,
+	"not":   NOT,
+	"true":  TRUE,
+	"false": FALSE,
+	"nil":   NIL,
+}
+
+// 词法单元, 字符串的 Text 是去掉引号并处理了转义后的内容
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Position
+}
+
+// 词法分析器, 把源码切分成词法单元
+type Lexer struct {
+	src    string
+	offset int
+	pos    Position
+}
+
+func NewLexer(src string) *Lexer {
+	return &Lexer{src: src, pos: Position{Line: 1, Column: 1}}
+}
+
+func (l *Lexer) peek() rune {
+	if l.offset >= len(l.src) {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.offset:])
+	return r
+}
+
+// 向后看第二个字符
+func (l *Lexer) peekSecond() rune {
+	if l.offset >= len(l.src) {
+		return utf8.RuneError
+	}
+	_, size := utf8.DecodeRuneInString(l.src[l.offset:])
+	if l.offset+size >= len(l.src) {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.offset+size:])
+	return r
+}
+
+func (l *Lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(l.src[l.offset:])
+	l.offset += size
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return r
+}
+
+func (l *Lexer) atEnd() bool {
+	return l.offset >= len(l.src)
+}
+
+// 读取下一个词法单元, 到达末尾后一直返回 EOF
+func (l *Lexer) Next() (Token, error) {
+	for !l.atEnd() && unicode.IsSpace(l.peek()) {
+		l.advance()
+	}
+	start, startOffset := l.pos, l.offset
+	if l.atEnd() {
+		return Token{Kind: EOF, Pos: start}, nil
+	}
+
+	r := l.peek()
+	switch {
+	case isDigit(r):
+		return l.number(), nil
+	case r == '"':
+		return l.string()
+	case isIdentStart(r):
+		for !l.atEnd() && isIdentPart(l.peek()) {
+			l.advance()
+		}
+		text := l.src[startOffset:l.offset]
+		if kind, ok := keywords[text]; ok {
+			return Token{Kind: kind, Text: text, Pos: start}, nil
+		}
+		return Token{Kind: IDENT, Text: text, Pos: start}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.offset:], op.text) {
+			for range op.text {
+				l.advance()
+			}
+			return Token{Kind: op.kind, Text: op.text, Pos: start}, nil
+		}
+	}
+	return Token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+// 整数或小数, 小数点后必须有数字
+func (l *Lexer) number() Token {
+	start, startOffset := l.pos, l.offset
+	for !l.atEnd() && isDigit(l.peek()) {
+		l.advance()
+	}
+	if l.peek() == '.' && isDigit(l.peekSecond()) {
+		l.advance()
+		for !l.atEnd() && isDigit(l.peek()) {
+			l.advance()
+		}
+	}
+	return Token{Kind: NUMBER, Text: l.src[startOffset:l.offset], Pos: start}
+}
+
+// 双引号字符串, 支持 \" \\ \n \t 转义
+func (l *Lexer) string() (Token, error) {
+	start := l.pos
+	l.advance()
+	var b strings.Builder
+	for {
+		if l.atEnd() || l.peek() == '\n' {
+			return Token{}, &SyntaxError{Pos: start, Msg: "unterminated string"}
+		}
+		escapePos := l.pos
+		r := l.advance()
+		switch r {
+		case '"':
+			return Token{Kind: STRING, Text: b.String(), Pos: start}, nil
+		case '\\':
+			if l.atEnd() {
+				return Token{}, &SyntaxError{Pos: start, Msg: "unterminated string"}
+			}
+			switch e := l.advance(); e {
+			case '"', '\\':
+				b.WriteRune(e)
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			default:
+				return Token{}, &SyntaxError{Pos: escapePos, Msg: fmt.Sprintf("unknown escape sequence \\%c", e)}
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
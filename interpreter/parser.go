@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 递归下降语法分析器, 文法如下(优先级从低到高, 二元运算符均为左结合):
+//
+//	expression     = let | assignment
+//	let            = "let" IDENT "=" expression { "," IDENT "=" expression } "in" expression
+//	assignment     = IDENT "=" expression | or
+//	or             = and { ("or" | "||") and }
+//	and            = equality { ("and" | "&&") equality }
+//	equality       = relational { ("==" | "!=") relational }
+//	relational     = additive { ("<" | "<=" | ">" | ">=") additive }
+//	additive       = multiplicative { ("+" | "-") multiplicative }
+//	multiplicative = unary { ("*" | "/" | "%") unary }
+//	unary          = "-" NUMBER | ("-" | "not" | "!") unary | primary
+//	primary        = NUMBER | STRING | "true" | "false" | "nil" | IDENT | IDENT "(" [ arguments ] ")"
+//	               | "(" expression ")" | "[" [ arguments ] "]"
+//	arguments      = expression { "," expression }
+type Parser struct {
+	lexer     *Lexer
+	tok       Token // 当前词法单元
+	positions Positions
+}
+
+// 表达式节点在源码中的位置。
+// 二元运算记录运算符的位置, 其他节点记录第一个词法单元的位置
+type Positions map[Expression]Position
+
+// 节点的位置, 没有记录时返回零值
+func (p Positions) Of(expr Expression) Position {
+	return p[expr]
+}
+
+// 把源码解析成表达式树
+func Parse(src string) (Expression, error) {
+	expr, _, err := ParseWithPositions(src)
+	return expr, err
+}
+
+// 解析源码, 同时返回每个节点的位置, 供静态分析报告诊断时使用
+func ParseWithPositions(src string) (Expression, Positions, error) {
+	p := &Parser{lexer: NewLexer(src), positions: make(Positions)}
+	if err := p.next(); err != nil {
+		return nil, nil, err
+	}
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.tok.Kind != EOF {
+		return nil, nil, p.unexpected()
+	}
+	return expr, p.positions, nil
+}
+
+// 记录节点的位置
+func (p *Parser) mark(expr Expression, pos Position) Expression {
+	p.positions[expr] = pos
+	return expr
+}
+
+func (p *Parser) next() error {
+	tok, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *Parser) expect(kind TokenKind) error {
+	if p.tok.Kind != kind {
+		return &SyntaxError{Pos: p.tok.Pos, Msg: fmt.Sprintf("expected %s, found %s", kind, p.describe())}
+	}
+	return p.next()
+}
+
+func (p *Parser) unexpected() error {
+	return &SyntaxError{Pos: p.tok.Pos, Msg: "unexpected " + p.describe()}
+}
+
+func (p *Parser) describe() string {
+	switch p.tok.Kind {
+	case NUMBER, IDENT:
+		return fmt.Sprintf("%s %q", p.tok.Kind, p.tok.Text)
+	case STRING:
+		return fmt.Sprintf("%s %s", p.tok.Kind, strconv.Quote(p.tok.Text))
+	}
+	return p.tok.Kind.String()
+}
+
+func (p *Parser) parseExpression() (Expression, error) {
+	if p.tok.Kind == LET {
+		return p.parseLet()
+	}
+	pos := p.tok.Pos
+	left, err := p.parseOr()
+	if err != nil || p.tok.Kind != ASSIGN {
+		return left, err
+	}
+	// 赋值是右结合的, 左边必须是变量
+	variable, ok := left.(*VariableExpression)
+	if !ok {
+		return nil, &SyntaxError{Pos: p.tok.Pos, Msg: "cannot assign to expression"}
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return p.mark(&AssignExpression{name: variable.name, value: value}, pos), nil
+}
+
+func (p *Parser) parseLet() (Expression, error) {
+	pos := p.tok.Pos
+	var bindings []binding
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name := p.tok.Text
+		if err := p.expect(IDENT); err != nil {
+			return nil, err
+		}
+		if err := p.expect(ASSIGN); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, binding{name: name, value: value})
+		if p.tok.Kind != COMMA {
+			break
+		}
+	}
+	if err := p.expect(IN); err != nil {
+		return nil, err
+	}
+	body, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return p.mark(&LetExpression{bindings: bindings, body: body}, pos), nil
+}
+
+// 解析一层左结合的二元运算, operand 解析更高优先级的一层
+func (p *Parser) parseBinary(operand func() (Expression, error), ops ...TokenKind) (Expression, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for slices.Contains(ops, p.tok.Kind) {
+		op, pos := p.tok.Kind, p.tok.Pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = p.mark(newBinary(op, left, right), pos)
+	}
+	return left, nil
+}
+
+func (p *Parser) parseOr() (Expression, error) {
+	return p.parseBinary(p.parseAnd, OR)
+}
+
+func (p *Parser) parseAnd() (Expression, error) {
+	return p.parseBinary(p.parseEquality, AND)
+}
+
+func (p *Parser) parseEquality() (Expression, error) {
+	return p.parseBinary(p.parseRelational, EQ, NE)
+}
+
+func (p *Parser) parseRelational() (Expression, error) {
+	return p.parseBinary(p.parseAdditive, LT, LE, GT, GE)
+}
+
+func (p *Parser) parseAdditive() (Expression, error) {
+	return p.parseBinary(p.parseMultiplicative, PLUS, MINUS)
+}
+
+func (p *Parser) parseMultiplicative() (Expression, error) {
+	return p.parseBinary(p.parseUnary, STAR, SLASH, PERCENT)
+}
+
+func (p *Parser) parseUnary() (Expression, error) {
+	op, pos := p.tok.Kind, p.tok.Pos
+	if op != MINUS && op != NOT {
+		return p.parsePrimary()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if op == MINUS && p.tok.Kind == NUMBER {
+		// 负号和数字一起解析, 否则 -9223372036854775808 的绝对值会超出 int64
+		tok := p.tok
+		tok.Text = "-" + tok.Text
+		expr, err := parseNumber(tok)
+		if err != nil {
+			return nil, err
+		}
+		return p.mark(expr, pos), p.next()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == MINUS {
+		return p.mark(&NegateExpression{operand: operand}, pos), nil
+	}
+	return p.mark(&NotExpression{operand: operand}, pos), nil
+}
+
+func (p *Parser) parsePrimary() (Expression, error) {
+	tok := p.tok
+	switch tok.Kind {
+	case NUMBER:
+		expr, err := parseNumber(tok)
+		if err != nil {
+			return nil, err
+		}
+		return p.mark(expr, tok.Pos), p.next()
+	case STRING:
+		return p.mark(&LiteralExpression{value: StringValue(tok.Text)}, tok.Pos), p.next()
+	case TRUE, FALSE:
+		return p.mark(&LiteralExpression{value: BoolValue(tok.Kind == TRUE)}, tok.Pos), p.next()
+	case NIL:
+		return p.mark(&LiteralExpression{value: NilValue}, tok.Pos), p.next()
+	case IDENT:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.Kind != LPAREN {
+			return p.mark(&VariableExpression{name: tok.Text}, tok.Pos), nil
+		}
+		args, err := p.parseArguments(RPAREN)
+		if err != nil {
+			return nil, err
+		}
+		return p.mark(&CallExpression{name: tok.Text, args: args}, tok.Pos), nil
+	case LPAREN:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(RPAREN)
+	case LBRACKET:
+		elements, err := p.parseArguments(RBRACKET)
+		if err != nil {
+			return nil, err
+		}
+		return p.mark(&ListExpression{elements: elements}, tok.Pos), nil
+	}
+	return nil, p.unexpected()
+}
+
+// 整数解析为 NumericExpression, 小数解析为浮点字面量
+func parseNumber(tok Token) (Expression, error) {
+	if strings.Contains(tok.Text, ".") {
+		f, err := strconv.ParseFloat(tok.Text, 64)
+		if err != nil {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("invalid number %q", tok.Text)}
+		}
+		return &LiteralExpression{value: FloatValue(f)}, nil
+	}
+	i, err := strconv.ParseInt(tok.Text, 10, 64)
+	if err != nil {
+		return nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("invalid number %q", tok.Text)}
+	}
+	return &NumericExpression{i}, nil
+}
+
+// 解析以逗号分隔的表达式列表, 当前词法单元为左括号, closing 为对应的右括号
+func (p *Parser) parseArguments(closing TokenKind) ([]Expression, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var args []Expression
+	if p.tok.Kind == closing {
+		return args, p.next()
+	}
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok.Kind != COMMA {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.expect(closing)
+}
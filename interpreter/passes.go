@@ -0,0 +1,359 @@
+package main
+
+import "slices"
+
+// 收集表达式读取和赋值的外部变量。
+// 提供了变量类型时, 对不在其中的变量给出警告
+type FreeVariables struct{}
+
+func (FreeVariables) Name() string { return "free-variables" }
+
+func (FreeVariables) Run(u *Unit) {
+	u.FreeVariables, u.AssignedVariables = freeVariables(u.Expr)
+	if u.Variables == nil {
+		return
+	}
+	reported := make(map[string]bool)
+	var visit func(expr Expression, bound map[string]int)
+	visit = func(expr Expression, bound map[string]int) {
+		name := ""
+		switch e := expr.(type) {
+		case *VariableExpression:
+			name = e.name
+		case *AssignExpression:
+			name = e.name
+		case *LetExpression:
+			for _, b := range e.bindings {
+				visit(b.value, bound)
+				bound[b.name]++
+			}
+			visit(e.body, bound)
+			for _, b := range e.bindings {
+				bound[b.name]--
+			}
+			return
+		}
+		for _, child := range children(expr) {
+			visit(child, bound)
+		}
+		if _, ok := u.Variables[name]; name != "" && bound[name] == 0 && !ok && !reported[name] {
+			reported[name] = true
+			u.Report(expr, SeverityWarning, "undefined variable %q", name)
+		}
+	}
+	visit(u.Expr, make(map[string]int))
+}
+
+// 推断表达式结果可能的类型, 对一定会出错的运算和调用报告错误
+type TypeInference struct{}
+
+func (TypeInference) Name() string { return "types" }
+
+func (TypeInference) Run(u *Unit) {
+	u.Type = (&typeChecker{unit: u, report: true}).infer(u.Expr, nil)
+}
+
+// 类型环境, 记录 let 绑定的变量的类型
+type typeEnv struct {
+	name   string
+	typ    ParamType
+	parent *typeEnv
+}
+
+func (env *typeEnv) lookup(name string) (ParamType, bool) {
+	for ; env != nil; env = env.parent {
+		if env.name == name {
+			return env.typ, true
+		}
+	}
+	return 0, false
+}
+
+type typeChecker struct {
+	unit   *Unit
+	report bool
+	types  map[Expression]ParamType // 不为 nil 时记录每个节点的类型
+}
+
+// 每种类型的一个代表值。运算结果的类型只取决于操作数的类型,
+// 因此对代表值做一次真实的运算就能得到结果类型, 并且与求值的规则保持一致
+var sampleValues = [...]Value{
+	NilKind:    NilValue,
+	IntKind:    IntValue(1),
+	FloatKind:  FloatValue(1),
+	BoolKind:   BoolValue(true),
+	StringKind: StringValue("s"),
+	ListKind:   ListValue(),
+}
+
+func kindsOf(t ParamType) []Kind {
+	var kinds []Kind
+	for k := NilKind; k <= ListKind; k++ {
+		if t&(1<<k) != 0 {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+func (c *typeChecker) errorf(expr Expression, format string, args ...any) {
+	if c.report {
+		c.unit.Report(expr, SeverityError, format, args...)
+	}
+}
+
+// 返回 0 表示求值一定会出错。操作数已经出错时不再重复报告
+func (c *typeChecker) infer(expr Expression, env *typeEnv) ParamType {
+	t := c.check(expr, env)
+	if c.types != nil {
+		c.types[expr] = t
+	}
+	return t
+}
+
+func (c *typeChecker) check(expr Expression, env *typeEnv) ParamType {
+	switch e := expr.(type) {
+	case *NumericExpression:
+		return ParamInt
+	case *LiteralExpression:
+		return 1 << e.value.Kind()
+	case *VariableExpression:
+		if t, ok := env.lookup(e.name); ok {
+			return t
+		}
+		if t, ok := c.unit.Variables[e.name]; ok {
+			return t
+		}
+		return ParamAny
+	case *AssignExpression:
+		return c.infer(e.value, env)
+	case *LetExpression:
+		for _, b := range e.bindings {
+			env = &typeEnv{name: b.name, typ: c.infer(b.value, env), parent: env}
+		}
+		return c.infer(e.body, env)
+	case *ListExpression:
+		for _, element := range e.elements {
+			c.infer(element, env)
+		}
+		return ParamList
+	case *CallExpression:
+		return c.call(e, env)
+	case *NegateExpression:
+		operand := c.infer(e.operand, env)
+		if operand == 0 {
+			return 0
+		}
+		if operand&ParamNumber == 0 {
+			c.errorf(e, "invalid operation: -%s", operand)
+		}
+		return operand & ParamNumber
+	case *NotExpression:
+		operand := c.infer(e.operand, env)
+		if operand != 0 && operand&ParamBool == 0 {
+			c.errorf(e, "operand of not must be bool, got %s", operand)
+			return 0
+		}
+		return operand & ParamBool
+	}
+	op, left, right, ok := binaryParts(expr)
+	if !ok {
+		return ParamAny
+	}
+	lt, rt := c.infer(left, env), c.infer(right, env)
+	if op == AND || op == OR {
+		// 右操作数只在左操作数不能决定结果时求值, 但它不是布尔值时规则本身就有问题
+		for _, t := range []ParamType{lt, rt} {
+			if t != 0 && t&ParamBool == 0 {
+				c.errorf(expr, "operand of %s must be bool, got %s", op.Symbol(), t)
+			}
+		}
+		if lt&ParamBool == 0 {
+			return 0
+		}
+		return ParamBool
+	}
+	if lt == 0 || rt == 0 {
+		return 0
+	}
+	var result ParamType
+	for _, a := range kindsOf(lt) {
+		for _, b := range kindsOf(rt) {
+			var v Value
+			var err error
+			if op == PLUS || op == MINUS || op == STAR || op == SLASH || op == PERCENT {
+				v, err = arithmetic(op, sampleValues[a], sampleValues[b])
+			} else {
+				v, err = relational(op, sampleValues[a], sampleValues[b])
+			}
+			if err == nil {
+				result |= 1 << v.Kind()
+			}
+		}
+	}
+	if result == 0 {
+		if op == PLUS || op == MINUS || op == STAR || op == SLASH || op == PERCENT {
+			c.errorf(expr, "invalid operation: %s %s %s", lt, op.Symbol(), rt)
+		} else {
+			c.errorf(expr, "cannot compare %s and %s", lt, rt)
+		}
+	}
+	return result
+}
+
+func (c *typeChecker) call(e *CallExpression, env *typeEnv) ParamType {
+	args := make([]ParamType, len(e.args))
+	for i, arg := range e.args {
+		args[i] = c.infer(arg, env)
+	}
+	fn, ok := c.unit.functions().Lookup(e.name)
+	if !ok {
+		c.errorf(e, "undefined function %q", e.name)
+		return 0
+	}
+	if err := fn.checkArity(len(args)); err != nil {
+		c.errorf(e, "%v", err)
+		return 0
+	}
+	for i, t := range args {
+		if t == 0 {
+			return 0
+		}
+		if param := fn.param(i); t&param == 0 {
+			c.errorf(e.args[i], "%s: argument %d must be %s, got %s", fn.Name, i+1, param, t)
+			return 0
+		}
+	}
+	if fn.Returns == 0 {
+		return ParamAny
+	}
+	return fn.Returns
+}
+
+// 报告除数是常量零的除法和取模
+type DivisionByZero struct{}
+
+func (DivisionByZero) Name() string { return "zero-division" }
+
+func (DivisionByZero) Run(u *Unit) {
+	f := make(folder)
+	var visit func(expr Expression)
+	visit = func(expr Expression) {
+		for _, child := range children(expr) {
+			visit(child)
+		}
+		var divisor Expression
+		switch e := expr.(type) {
+		case *DivideExpression:
+			divisor = e.right
+		case *ModuloExpression:
+			divisor = e.right
+		default:
+			return
+		}
+		if v, ok := f.fold(divisor); ok {
+			if x, ok := v.AsFloat(); ok && x == 0 {
+				u.Report(expr, SeverityError, "division by constant zero")
+			}
+		}
+	}
+	visit(u.Expr)
+}
+
+// 删除不会被求值或者不影响结果的分支:
+//
+//	false and x => false    true or x => true    (x 不会被求值, 给出警告)
+//	true and x  => x        false or x => x      (x 只能是布尔值时)
+//	let 中没有被使用的常量绑定                     (给出警告)
+type DeadBranchElimination struct{}
+
+func (DeadBranchElimination) Name() string { return "dead-branches" }
+
+func (DeadBranchElimination) Run(u *Unit) {
+	f := make(folder)
+	// 改写前先推断原树中每个节点的类型, 改写只会把节点换成它的子树或常量
+	types := make(map[Expression]ParamType)
+	(&typeChecker{unit: u, types: types}).infer(u.Expr, nil)
+	unused := make(map[Expression]bool)
+	u.Expr = u.rewrite(u.Expr, func(expr Expression) Expression {
+		switch e := expr.(type) {
+		case *AndExpression:
+			return eliminateBranch(u, f, types, e, AND, e.left, e.right)
+		case *OrExpression:
+			return eliminateBranch(u, f, types, e, OR, e.left, e.right)
+		case *LetExpression:
+			return eliminateBindings(u, f, unused, e)
+		}
+		return expr
+	})
+}
+
+func eliminateBranch(u *Unit, f folder, types map[Expression]ParamType, expr Expression, op TokenKind, left, right Expression) Expression {
+	v, ok := f.fold(left)
+	if !ok || v.Kind() != BoolKind {
+		return expr
+	}
+	// and 在左操作数为 false 时短路, or 在左操作数为 true 时短路
+	if v.bool() == (op == OR) {
+		u.Report(right, SeverityWarning, "right operand of %s is never evaluated", op.Symbol())
+		return &LiteralExpression{value: v}
+	}
+	if t, ok := types[right]; ok && t == ParamBool {
+		return right
+	}
+	return expr
+}
+
+// unused 记录已经报告过的绑定, 改写后的 let 会再次经过这里
+func eliminateBindings(u *Unit, f folder, unused map[Expression]bool, let *LetExpression) Expression {
+	var kept []binding
+	for i, b := range let.bindings {
+		var rest Expression = let.body
+		if i+1 < len(let.bindings) {
+			rest = &LetExpression{bindings: let.bindings[i+1:], body: let.body}
+		}
+		reads, writes := freeVariables(rest)
+		if slices.Contains(reads, b.name) || slices.Contains(writes, b.name) {
+			kept = append(kept, b)
+			continue
+		}
+		if !unused[b.value] {
+			unused[b.value] = true
+			u.Report(b.value, SeverityWarning, "variable %q is never used", b.name)
+		}
+		// 只删除常量绑定, 其他绑定的求值可能出错或者有副作用
+		if _, ok := f.fold(b.value); !ok {
+			kept = append(kept, b)
+		}
+	}
+	switch len(kept) {
+	case len(let.bindings):
+		return let
+	case 0:
+		return let.body
+	}
+	return &LetExpression{bindings: kept, body: let.body}
+}
+
+// 把常量子表达式替换为字面量
+type ConstantFolding struct{}
+
+func (ConstantFolding) Name() string { return "constant-folding" }
+
+func (ConstantFolding) Run(u *Unit) {
+	f := make(folder)
+	u.Expr = u.rewrite(u.Expr, func(expr Expression) Expression {
+		switch expr.(type) {
+		case *NumericExpression, *LiteralExpression:
+			return expr
+		}
+		v, ok := f.fold(expr)
+		if !ok {
+			return expr
+		}
+		if i, ok := v.AsInt(); ok {
+			return &NumericExpression{i}
+		}
+		return &LiteralExpression{value: v}
+	})
+}
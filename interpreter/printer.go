@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 运算符优先级, 数值越大结合越紧, 与 Parser 的文法一致
+const (
+	precLowest         = iota
+	precAssign         // let, 赋值
+	precOr             // or
+	precAnd            // and
+	precEquality       // == !=
+	precRelational     // < <= > >=
+	precAdditive       // + -
+	precMultiplicative // * / %
+	precUnary          // - not
+	precPrimary        // 字面量、变量、调用、括号
+)
+
+// 把表达式格式化为规范的中缀形式, 只在必要时加括号。
+// 输出可以被 Parse 解析回等价的表达式, 并且 Format(Parse(Format(e))) == Format(e)
+func Format(expr Expression) string {
+	var b strings.Builder
+	format(&b, expr)
+	return b.String()
+}
+
+func format(b *strings.Builder, expr Expression) {
+	switch e := expr.(type) {
+	case *NumericExpression:
+		b.WriteString(strconv.FormatInt(e.value, 10))
+	case *LiteralExpression:
+		formatValue(b, e.value)
+	case *VariableExpression:
+		b.WriteString(e.name)
+	case *CallExpression:
+		b.WriteString(e.name)
+		formatList(b, "(", e.args, ")")
+	case *ListExpression:
+		formatList(b, "[", e.elements, "]")
+	case *NegateExpression:
+		b.WriteString("-")
+		// 避免输出 "--1" 这样难以阅读的形式
+		if operand := Format(e.operand); strings.HasPrefix(operand, "-") {
+			b.WriteString("(" + operand + ")")
+			return
+		}
+		formatOperand(b, e.operand, precUnary)
+	case *NotExpression:
+		b.WriteString("not ")
+		formatOperand(b, e.operand, precUnary)
+	case *AssignExpression:
+		b.WriteString(e.name + " = ")
+		format(b, e.value)
+	case *LetExpression:
+		b.WriteString("let ")
+		for i, binding := range e.bindings {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(binding.name + " = ")
+			formatOperand(b, binding.value, precOr)
+		}
+		b.WriteString(" in ")
+		format(b, e.body)
+	default:
+		op, left, right, ok := binaryParts(expr)
+		if !ok {
+			b.WriteString("<?>")
+			return
+		}
+		// 二元运算符都是左结合的: 左操作数优先级不低于当前运算符即可, 右操作数必须更高
+		prec := precedence(expr)
+		formatOperand(b, left, prec)
+		b.WriteString(" " + op.Symbol() + " ")
+		formatOperand(b, right, prec+1)
+	}
+}
+
+// 操作数的优先级低于 min 时加括号
+func formatOperand(b *strings.Builder, expr Expression, min int) {
+	if precedence(expr) < min {
+		b.WriteString("(")
+		format(b, expr)
+		b.WriteString(")")
+		return
+	}
+	format(b, expr)
+}
+
+func formatList(b *strings.Builder, open string, items []Expression, close string) {
+	b.WriteString(open)
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		format(b, item)
+	}
+	b.WriteString(close)
+}
+
+// 以字面量形式输出值, 格式与 Lexer 支持的写法一致
+func formatValue(b *strings.Builder, v Value) {
+	switch v.Kind() {
+	case FloatKind:
+		f := v.float()
+		s := strconv.FormatFloat(math.Abs(f), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		// -0.0 也保留负号, 解析回来仍然是 -0.0
+		if math.Signbit(f) {
+			s = "-" + s
+		}
+		b.WriteString(s)
+	case StringKind:
+		b.WriteString(quote(v.str()))
+	case ListKind:
+		b.WriteString("[")
+		for i, item := range v.items() {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			formatValue(b, item)
+		}
+		b.WriteString("]")
+	default:
+		b.WriteString(v.String())
+	}
+}
+
+// 给字符串加引号, 只使用 Lexer 支持的转义
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func precedence(expr Expression) int {
+	switch e := expr.(type) {
+	case *AssignExpression, *LetExpression:
+		return precAssign
+	case *OrExpression:
+		return precOr
+	case *AndExpression:
+		return precAnd
+	case *CompareExpression:
+		if e.op == EQ || e.op == NE {
+			return precEquality
+		}
+		return precRelational
+	case *PlusExpression, *MinusExpression:
+		return precAdditive
+	case *MultiplyExpression, *DivideExpression, *ModuloExpression:
+		return precMultiplicative
+	case *NegateExpression, *NotExpression:
+		return precUnary
+	case *NumericExpression:
+		// 负数字面量相当于一元负号
+		if e.value < 0 {
+			return precUnary
+		}
+	case *LiteralExpression:
+		if f, ok := e.value.AsFloat(); ok && math.Signbit(f) {
+			return precUnary
+		}
+	}
+	return precPrimary
+}
+
+// 拆出二元表达式的运算符和左右操作数
+func binaryParts(expr Expression) (op TokenKind, left, right Expression, ok bool) {
+	switch e := expr.(type) {
+	case *PlusExpression:
+		return PLUS, e.left, e.right, true
+	case *MinusExpression:
+		return MINUS, e.left, e.right, true
+	case *MultiplyExpression:
+		return STAR, e.left, e.right, true
+	case *DivideExpression:
+		return SLASH, e.left, e.right, true
+	case *ModuloExpression:
+		return PERCENT, e.left, e.right, true
+	case *CompareExpression:
+		return e.op, e.left, e.right, true
+	case *AndExpression:
+		return AND, e.left, e.right, true
+	case *OrExpression:
+		return OR, e.left, e.right, true
+	}
+	return 0, nil, nil, false
+}
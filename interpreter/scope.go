@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// 读取或赋值了一个没有定义的变量
+type UndefinedVariableError struct {
+	Name string
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable %q", e.Name)
+}
+
+// 调用了一个没有定义的函数
+type UndefinedFunctionError struct {
+	Name string
+}
+
+func (e *UndefinedFunctionError) Error() string {
+	return fmt.Sprintf("undefined function %q", e.Name)
+}
+
+// 变量作用域, 查找变量时由内向外逐层查找
+type Scope struct {
+	parent    *Scope
+	variables map[string]Value
+}
+
+func NewScope(parent *Scope) *Scope {
+	return &Scope{parent: parent, variables: make(map[string]Value)}
+}
+
+// 在当前作用域定义变量, 会遮蔽外层的同名变量
+func (s *Scope) Define(name string, value Value) {
+	s.variables[name] = value
+}
+
+// 由内向外查找变量
+func (s *Scope) Lookup(name string) (Value, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.variables[name]; ok {
+			return value, true
+		}
+	}
+	return NilValue, false
+}
+
+// 给最近一层定义了该变量的作用域赋值, 变量没有定义时返回 false
+func (s *Scope) Assign(name string, value Value) bool {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.variables[name]; ok {
+			scope.variables[name] = value
+			return true
+		}
+	}
+	return false
+}
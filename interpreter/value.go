@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrDivisionByZero = errors.New("division by zero")
+
+// 运算对象的类型不符合要求
+type TypeError struct {
+	Msg string
+}
+
+func (e *TypeError) Error() string {
+	return "type error: " + e.Msg
+}
+
+// 值的类型
+type Kind int
+
+const (
+	NilKind Kind = iota
+	IntKind
+	FloatKind
+	BoolKind
+	StringKind
+	ListKind
+)
+
+var kindNames = [...]string{
+	NilKind:    "nil",
+	IntKind:    "int",
+	FloatKind:  "float",
+	BoolKind:   "bool",
+	StringKind: "string",
+	ListKind:   "list",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// 带类型标签的值, 零值为 nil。
+// 为了让值尽量小(求值和虚拟机执行时会大量复制), 整数、浮点数和布尔值共用 n 保存,
+// 字符串和列表保存在 ref 中
+type Value struct {
+	kind Kind
+	n    uint64
+	ref  any
+}
+
+var NilValue = Value{}
+
+func IntValue(i int64) Value {
+	return Value{kind: IntKind, n: uint64(i)}
+}
+
+func FloatValue(f float64) Value {
+	return Value{kind: FloatKind, n: math.Float64bits(f)}
+}
+
+func BoolValue(b bool) Value {
+	v := Value{kind: BoolKind}
+	if b {
+		v.n = 1
+	}
+	return v
+}
+
+func StringValue(s string) Value {
+	return Value{kind: StringKind, ref: s}
+}
+
+func ListValue(items ...Value) Value {
+	return Value{kind: ListKind, ref: items}
+}
+
+// 以下方法不检查类型, 调用前需要确认 kind
+func (v Value) int() int64 {
+	return int64(v.n)
+}
+
+func (v Value) float() float64 {
+	return math.Float64frombits(v.n)
+}
+
+func (v Value) bool() bool {
+	return v.n != 0
+}
+
+func (v Value) str() string {
+	s, _ := v.ref.(string)
+	return s
+}
+
+func (v Value) items() []Value {
+	list, _ := v.ref.([]Value)
+	return list
+}
+
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+func (v Value) IsNil() bool {
+	return v.kind == NilKind
+}
+
+func (v Value) AsInt() (int64, bool) {
+	return v.int(), v.kind == IntKind
+}
+
+// 整数会被转换为浮点数
+func (v Value) AsFloat() (float64, bool) {
+	switch v.kind {
+	case IntKind:
+		return float64(v.int()), true
+	case FloatKind:
+		return v.float(), true
+	}
+	return 0, false
+}
+
+func (v Value) AsBool() (bool, bool) {
+	return v.bool(), v.kind == BoolKind
+}
+
+func (v Value) AsString() (string, bool) {
+	return v.str(), v.kind == StringKind
+}
+
+func (v Value) AsList() ([]Value, bool) {
+	return v.items(), v.kind == ListKind
+}
+
+func (v Value) isNumber() bool {
+	return v.kind == IntKind || v.kind == FloatKind
+}
+
+// 值的字面量形式, 字符串带引号
+func (v Value) String() string {
+	switch v.kind {
+	case IntKind:
+		return strconv.FormatInt(v.int(), 10)
+	case FloatKind:
+		s := strconv.FormatFloat(v.float(), 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0" // 保证浮点数和整数的字面量不同
+		}
+		return s
+	case BoolKind:
+		return strconv.FormatBool(v.bool())
+	case StringKind:
+		return strconv.Quote(v.str())
+	case ListKind:
+		items := make([]string, len(v.items()))
+		for i, item := range v.items() {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return "nil"
+}
+
+// 判断两个值是否相等, 整数和浮点数按数值比较, 列表逐个元素比较
+func Equal(a, b Value) bool {
+	if a.isNumber() && b.isNumber() {
+		if a.kind == IntKind && b.kind == IntKind {
+			return a.int() == b.int()
+		}
+		x, _ := a.AsFloat()
+		y, _ := b.AsFloat()
+		return x == y
+	}
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case BoolKind:
+		return a.bool() == b.bool()
+	case StringKind:
+		return a.str() == b.str()
+	case ListKind:
+		if len(a.items()) != len(b.items()) {
+			return false
+		}
+		for i := range a.items() {
+			if !Equal(a.items()[i], b.items()[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 比较两个数值或两个字符串的大小, 返回 -1, 0, 1
+func Compare(a, b Value) (int, error) {
+	switch {
+	case a.kind == IntKind && b.kind == IntKind:
+		return cmp(a.int(), b.int()), nil
+	case a.isNumber() && b.isNumber():
+		x, _ := a.AsFloat()
+		y, _ := b.AsFloat()
+		return cmp(x, y), nil
+	case a.kind == StringKind && b.kind == StringKind:
+		return strings.Compare(a.str(), b.str()), nil
+	}
+	return 0, &TypeError{Msg: fmt.Sprintf("cannot compare %s and %s", a.kind, b.kind)}
+}
+
+func cmp[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// 二元算术运算, 两个整数的结果为整数, 有浮点数参与时结果为浮点数;
+// + 还可以连接两个字符串或两个列表
+func arithmetic(op TokenKind, a, b Value) (Value, error) {
+	if op == PLUS {
+		switch {
+		case a.kind == StringKind && b.kind == StringKind:
+			return StringValue(a.str() + b.str()), nil
+		case a.kind == ListKind && b.kind == ListKind:
+			list := make([]Value, 0, len(a.items())+len(b.items()))
+			return ListValue(append(append(list, a.items()...), b.items()...)...), nil
+		}
+	}
+	if !a.isNumber() || !b.isNumber() {
+		return NilValue, &TypeError{Msg: fmt.Sprintf("invalid operation: %s %s %s", a.kind, op.Symbol(), b.kind)}
+	}
+	if a.kind == IntKind && b.kind == IntKind {
+		x, y := a.int(), b.int()
+		switch op {
+		case PLUS:
+			return IntValue(x + y), nil
+		case MINUS:
+			return IntValue(x - y), nil
+		case STAR:
+			return IntValue(x * y), nil
+		case SLASH:
+			if y == 0 {
+				return NilValue, ErrDivisionByZero
+			}
+			return IntValue(x / y), nil
+		case PERCENT:
+			if y == 0 {
+				return NilValue, ErrDivisionByZero
+			}
+			return IntValue(x % y), nil
+		}
+	}
+	x, _ := a.AsFloat()
+	y, _ := b.AsFloat()
+	switch op {
+	case PLUS:
+		return FloatValue(x + y), nil
+	case MINUS:
+		return FloatValue(x - y), nil
+	case STAR:
+		return FloatValue(x * y), nil
+	case SLASH:
+		if y == 0 {
+			return NilValue, ErrDivisionByZero
+		}
+		return FloatValue(x / y), nil
+	}
+	return NilValue, &TypeError{Msg: fmt.Sprintf("invalid operation: %s %s %s", a.kind, op.Symbol(), b.kind)}
+}
+
+// 关系运算
+func relational(op TokenKind, a, b Value) (Value, error) {
+	switch op {
+	case EQ:
+		return BoolValue(Equal(a, b)), nil
+	case NE:
+		return BoolValue(!Equal(a, b)), nil
+	}
+	c, err := Compare(a, b)
+	if err != nil {
+		return NilValue, err
+	}
+	switch op {
+	case LT:
+		return BoolValue(c < 0), nil
+	case LE:
+		return BoolValue(c <= 0), nil
+	case GT:
+		return BoolValue(c > 0), nil
+	case GE:
+		return BoolValue(c >= 0), nil
+	}
+	return NilValue, fmt.Errorf("unknown comparison operator %s", op)
+}
+
+// 要求值为布尔类型, 用于 and/or/not 的操作数
+func truth(v Value, op TokenKind) (bool, error) {
+	b, ok := v.AsBool()
+	if !ok {
+		return false, &TypeError{Msg: fmt.Sprintf("operand of %s must be bool, got %s", op.Symbol(), v.kind)}
+	}
+	return b, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 基于栈的虚拟机, 执行编译后的字节码。
+// VM 会复用操作数栈和 let 变量的槽位, 不能被多个 goroutine 同时使用
+type VM struct {
+	stack  []Value
+	locals []Value
+}
+
+func NewVM() *VM {
+	return &VM{stack: make([]Value, 0, 64)}
+}
+
+// Program.Run 使用的虚拟机, 避免每次执行都分配操作数栈
+var vmPool = sync.Pool{New: func() any { return NewVM() }}
+
+// 在环境中执行程序, 结果与对原表达式调用 Interpret 相同, 可以被多个 goroutine 同时调用
+func (p *Program) Run(context *Context) (Value, error) {
+	vm := vmPool.Get().(*VM)
+	defer vmPool.Put(vm)
+	return vm.Run(p, context)
+}
+
+func (vm *VM) Run(p *Program, context *Context) (Value, error) {
+	// 编译时已经算出栈的最大深度, 执行过程中不会再扩容
+	if cap(vm.stack) < p.stackSize {
+		vm.stack = make([]Value, 0, p.stackSize)
+	}
+	if cap(vm.locals) < len(p.locals) {
+		vm.locals = make([]Value, len(p.locals))
+	}
+	locals := vm.locals[:len(p.locals)]
+	// 不让复用的虚拟机继续引用本次执行的值
+	defer clear(locals)
+	stack := vm.stack[:0]
+	code := p.code
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.Op {
+		case OpConst:
+			stack = append(stack, p.constants[in.Arg])
+		case OpLoad:
+			v, ok := context.scope.Lookup(p.names[in.Arg])
+			if !ok {
+				return NilValue, &UndefinedVariableError{Name: p.names[in.Arg]}
+			}
+			stack = append(stack, v)
+		case OpStore:
+			if !context.scope.Assign(p.names[in.Arg], stack[len(stack)-1]) {
+				return NilValue, &UndefinedVariableError{Name: p.names[in.Arg]}
+			}
+		case OpLoadLocal:
+			stack = append(stack, locals[in.Arg])
+		case OpStoreLocal:
+			locals[in.Arg] = stack[len(stack)-1]
+		case OpDefineLocal:
+			locals[in.Arg] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case OpAdd, OpSub, OpMul, OpLt, OpLe, OpGt, OpGe, OpEq, OpNe:
+			a, b := &stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// 整数运算的快速路径
+			if a.kind == IntKind && b.kind == IntKind {
+				*a = intOp(in.Op, a.int(), b.int())
+				continue
+			}
+			v, err := binaryOp(in.Op, *a, b)
+			if err != nil {
+				return NilValue, err
+			}
+			*a = v
+		case OpDiv, OpMod:
+			a, b := &stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			v, err := binaryOp(in.Op, *a, b)
+			if err != nil {
+				return NilValue, err
+			}
+			*a = v
+		case OpNeg:
+			top := &stack[len(stack)-1]
+			switch top.kind {
+			case IntKind:
+				*top = IntValue(-top.int())
+			case FloatKind:
+				*top = FloatValue(-top.float())
+			default:
+				return NilValue, &TypeError{Msg: fmt.Sprintf("invalid operation: -%s", top.kind)}
+			}
+		case OpNot:
+			top := &stack[len(stack)-1]
+			b, err := truth(*top, NOT)
+			if err != nil {
+				return NilValue, err
+			}
+			*top = BoolValue(!b)
+		case OpBool:
+			if _, err := truth(stack[len(stack)-1], TokenKind(in.Arg)); err != nil {
+				return NilValue, err
+			}
+		case OpJumpIfFalse, OpJumpIfTrue:
+			op := AND
+			if in.Op == OpJumpIfTrue {
+				op = OR
+			}
+			b, err := truth(stack[len(stack)-1], op)
+			if err != nil {
+				return NilValue, err
+			}
+			if b == (in.Op == OpJumpIfTrue) {
+				pc = int(in.Arg) - 1
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		case OpCall:
+			site := p.calls[in.Arg]
+			fn, ok := context.Functions().Lookup(site.name)
+			if !ok {
+				return NilValue, &UndefinedFunctionError{Name: site.name}
+			}
+			// 参数直接在栈上传给函数, 限制容量让函数实现 append 时得到新的数组
+			base := len(stack) - site.argc
+			v, err := fn.Call(stack[base:len(stack):len(stack)])
+			if err != nil {
+				return NilValue, err
+			}
+			stack = append(stack[:base], v)
+		case OpList:
+			base := len(stack) - int(in.Arg)
+			items := make([]Value, in.Arg)
+			copy(items, stack[base:])
+			stack = append(stack[:base], ListValue(items...))
+		default:
+			return NilValue, fmt.Errorf("vm: unknown opcode %s", in.Op)
+		}
+	}
+	v := stack[len(stack)-1]
+	clear(stack)
+	return v, nil
+}
+
+func intOp(op Opcode, x, y int64) Value {
+	switch op {
+	case OpAdd:
+		return IntValue(x + y)
+	case OpSub:
+		return IntValue(x - y)
+	case OpMul:
+		return IntValue(x * y)
+	case OpLt:
+		return BoolValue(x < y)
+	case OpLe:
+		return BoolValue(x <= y)
+	case OpGt:
+		return BoolValue(x > y)
+	case OpGe:
+		return BoolValue(x >= y)
+	case OpEq:
+		return BoolValue(x == y)
+	}
+	return BoolValue(x != y)
+}
+
+func binaryOp(op Opcode, a, b Value) (Value, error) {
+	switch op {
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return relational(opcodeOperators[op], a, b)
+	}
+	return arithmetic(opcodeOperators[op], a, b)
+}
@@ -0,0 +1,81 @@
+package main
+
+import "fmt"
+
+// 双向迭代器, 游标位于两个元素之间:
+// Next 返回游标后面的元素并后移, Prev 返回游标前面的元素并前移,
+// 所以交替调用 Next 和 Prev 会返回同一个元素。
+// 同 Next 一样, Prev 只能在 HasPrev 返回 true 时调用
+type BidirectionalIterator[T any] interface {
+	Iterator[T]
+	HasPrev() bool
+	Prev() T
+}
+
+// 可定位的迭代器
+type SeekableIterator[T any] interface {
+	BidirectionalIterator[T]
+	// 游标位置, 即下一次 Next 将返回的元素下标, 取值范围 [0, Len()]
+	Index() int
+	// 把游标移动到 index 处, index 超出 [0, Len()] 时返回 ErrIndexOutOfRange
+	Seek(index int) error
+	// 游标回到开头, 并接受迭代器创建后对集合的修改
+	Reset()
+	// 查看下一个元素但不移动游标, 没有下一个元素时第二个返回值为 false
+	Peek() (T, bool)
+}
+
+// 创建一个可双向移动和定位的迭代器, 同样会检测并发修改
+func (c *Collection[T]) CreateSeekableIterator() SeekableIterator[T] {
+	return &CollectionIterator[T]{
+		collection:       c,
+		index:            0,
+		expectedModCount: c.modCount,
+	}
+}
+
+// 判断是否还有上一个元素可以访问
+func (it *CollectionIterator[T]) HasPrev() bool {
+	return it.valid() && it.index > 0
+}
+
+// 获取上一个元素, 没有上一个元素时 panic
+func (it *CollectionIterator[T]) Prev() T {
+	if !it.HasPrev() {
+		if it.err != nil {
+			panic(it.err)
+		}
+		panic(fmt.Errorf("%w: index %d", ErrNoSuchElement, it.index-1))
+	}
+	it.index--
+	return it.collection.data[it.index]
+}
+
+func (it *CollectionIterator[T]) Index() int {
+	return it.index
+}
+
+func (it *CollectionIterator[T]) Seek(index int) error {
+	if !it.valid() {
+		return it.err
+	}
+	if index < 0 || index > len(it.collection.data) {
+		return fmt.Errorf("%w: %d", ErrIndexOutOfRange, index)
+	}
+	it.index = index
+	return nil
+}
+
+func (it *CollectionIterator[T]) Reset() {
+	it.index = 0
+	it.expectedModCount = it.collection.modCount
+	it.err = nil
+}
+
+func (it *CollectionIterator[T]) Peek() (T, bool) {
+	if !it.HasNext() {
+		var zero T
+		return zero, false
+	}
+	return it.collection.data[it.index], true
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 图中存在环, Path 为环上的顶点, 首尾相同
+type CycleError[K comparable] struct {
+	Path []K
+}
+
+func (e *CycleError[K]) Error() string {
+	return fmt.Sprintf("iterator: graph has a cycle %v", e.Path)
+}
+
+// 用邻接表表示的有向图, 顶点和邻接点都按加入顺序遍历
+type Graph[K comparable] struct {
+	vertices  []K
+	adjacency map[K][]K
+}
+
+func NewGraph[K comparable]() *Graph[K] {
+	return &Graph[K]{adjacency: make(map[K][]K)}
+}
+
+// 添加顶点, 已存在时忽略
+func (g *Graph[K]) AddVertex(v K) {
+	if _, ok := g.adjacency[v]; !ok {
+		g.vertices = append(g.vertices, v)
+		g.adjacency[v] = nil
+	}
+}
+
+// 添加一条有向边 from -> to, 顶点不存在时自动添加
+func (g *Graph[K]) AddEdge(from, to K) {
+	g.AddVertex(from)
+	g.AddVertex(to)
+	g.adjacency[from] = append(g.adjacency[from], to)
+}
+
+// 广度优先迭代器, 已访问的顶点不会重复返回, 因此有环时也能正常结束
+type BFSIterator[K comparable] struct {
+	graph   *Graph[K]
+	queue   []K
+	visited map[K]bool
+}
+
+// 创建从 start 出发的广度优先迭代器, start 不在图中时迭代器为空
+func (g *Graph[K]) CreateBFSIterator(start K) Iterator[K] {
+	it := &BFSIterator[K]{graph: g, visited: make(map[K]bool)}
+	if _, ok := g.adjacency[start]; ok {
+		it.queue = append(it.queue, start)
+		it.visited[start] = true
+	}
+	return it
+}
+
+func (it *BFSIterator[K]) HasNext() bool {
+	return len(it.queue) > 0
+}
+
+func (it *BFSIterator[K]) Next() K {
+	if !it.HasNext() {
+		panic(ErrNoSuchElement)
+	}
+	v := it.queue[0]
+	it.queue = it.queue[1:]
+	for _, w := range it.graph.adjacency[v] {
+		if !it.visited[w] {
+			it.visited[w] = true
+			it.queue = append(it.queue, w)
+		}
+	}
+	return v
+}
+
+// 深度优先(前序)迭代器, 同样跳过已访问的顶点
+type DFSIterator[K comparable] struct {
+	graph   *Graph[K]
+	stack   []K
+	visited map[K]bool
+}
+
+// 创建从 start 出发的深度优先迭代器, start 不在图中时迭代器为空
+func (g *Graph[K]) CreateDFSIterator(start K) Iterator[K] {
+	it := &DFSIterator[K]{graph: g, visited: make(map[K]bool)}
+	if _, ok := g.adjacency[start]; ok {
+		it.stack = append(it.stack, start)
+	}
+	return it
+}
+
+// 弹出栈顶已访问过的顶点, 使 HasNext 的结果准确
+func (it *DFSIterator[K]) skipVisited() {
+	for len(it.stack) > 0 && it.visited[it.stack[len(it.stack)-1]] {
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+}
+
+func (it *DFSIterator[K]) HasNext() bool {
+	it.skipVisited()
+	return len(it.stack) > 0
+}
+
+func (it *DFSIterator[K]) Next() K {
+	if !it.HasNext() {
+		panic(ErrNoSuchElement)
+	}
+	v := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	it.visited[v] = true
+	// 逆序压栈, 保证按邻接表顺序访问
+	neighbors := it.graph.adjacency[v]
+	for i := len(neighbors) - 1; i >= 0; i-- {
+		if !it.visited[neighbors[i]] {
+			it.stack = append(it.stack, neighbors[i])
+		}
+	}
+	return v
+}
+
+// 查找图中的一个环, 没有环时第二个返回值为 false
+func (g *Graph[K]) FindCycle() ([]K, bool) {
+	const (
+		white = iota // 未访问
+		gray         // 在当前路径上
+		black        // 已完成
+	)
+	color := make(map[K]int, len(g.vertices))
+	var path []K
+	var visit func(v K) []K
+	visit = func(v K) []K {
+		color[v] = gray
+		path = append(path, v)
+		for _, w := range g.adjacency[v] {
+			switch color[w] {
+			case gray:
+				// 回边: 从路径上 w 的位置到当前顶点构成环
+				start := slices.Index(path, w)
+				return append(slices.Clone(path[start:]), w)
+			case white:
+				if cycle := visit(w); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		color[v] = black
+		return nil
+	}
+	for _, v := range g.vertices {
+		if color[v] == white {
+			if cycle := visit(v); cycle != nil {
+				return cycle, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// 创建拓扑排序迭代器(Kahn 算法, 同层按顶点加入顺序), 图中有环时返回 *CycleError
+func (g *Graph[K]) CreateTopologicalIterator() (Iterator[K], error) {
+	if cycle, ok := g.FindCycle(); ok {
+		return nil, &CycleError[K]{Path: cycle}
+	}
+	inDegree := make(map[K]int, len(g.vertices))
+	for _, v := range g.vertices {
+		for _, w := range g.adjacency[v] {
+			inDegree[w]++
+		}
+	}
+	order := make([]K, 0, len(g.vertices))
+	for _, v := range g.vertices {
+		if inDegree[v] == 0 {
+			order = append(order, v)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, w := range g.adjacency[order[i]] {
+			inDegree[w]--
+			if inDegree[w] == 0 {
+				order = append(order, w)
+			}
+		}
+	}
+	return NewCollection(order...).CreateIterator(), nil
+}
@@ -1,52 +1,147 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
 
-// 定义一个迭代器接口
-type Iterator interface {
+var (
+	ErrIndexOutOfRange = errors.New("iterator: index out of range")
+	ErrNoSuchElement   = errors.New("iterator: no such element")
+)
+
+// 迭代过程中集合发生了结构性修改(增删元素)
+type ConcurrentModificationError struct {
+	Expected int // 创建迭代器时集合的修改次数
+	Actual   int // 检测到修改时集合的修改次数
+}
+
+func (e *ConcurrentModificationError) Error() string {
+	return fmt.Sprintf("iterator: collection modified during iteration (mod count %d, expected %d)", e.Actual, e.Expected)
+}
+
+// 定义一个迭代器接口, T 为元素类型
+// Next 只能在 HasNext 返回 true 时调用, 越界调用会以 ErrNoSuchElement panic,
+// 这样零值(包括 nil)也可以是合法的元素
+type Iterator[T any] interface {
 	HasNext() bool
-	Next() interface{}
+	Next() T
 }
 
 // 定义一个集合结构体
-type Collection struct {
-	data []interface{}
+type Collection[T any] struct {
+	data     []T
+	modCount int // 每次结构性修改加 1, 迭代器据此检测并发修改
+}
+
+// 创建一个包含给定元素的集合
+func NewCollection[T any](items ...T) *Collection[T] {
+	return &Collection[T]{data: items}
+}
+
+// 集合中元素的个数
+func (c *Collection[T]) Len() int {
+	return len(c.data)
+}
+
+// 在末尾追加元素
+func (c *Collection[T]) Add(items ...T) {
+	c.data = append(c.data, items...)
+	c.modCount++
+}
+
+// 在 index 处插入元素, index 可以等于 Len(), 相当于追加
+func (c *Collection[T]) Insert(index int, item T) error {
+	if index < 0 || index > len(c.data) {
+		return fmt.Errorf("%w: %d", ErrIndexOutOfRange, index)
+	}
+	c.data = append(c.data, item)
+	copy(c.data[index+1:], c.data[index:])
+	c.data[index] = item
+	c.modCount++
+	return nil
+}
+
+// 删除并返回 index 处的元素
+func (c *Collection[T]) Remove(index int) (T, error) {
+	var zero T
+	if index < 0 || index >= len(c.data) {
+		return zero, fmt.Errorf("%w: %d", ErrIndexOutOfRange, index)
+	}
+	value := c.data[index]
+	copy(c.data[index:], c.data[index+1:])
+	c.data[len(c.data)-1] = zero
+	c.data = c.data[:len(c.data)-1]
+	c.modCount++
+	return value, nil
 }
 
 // 实现迭代器接口
-type CollectionIterator struct {
-	collection *Collection
-	index      int
+// 迭代器是快速失败的: 创建后集合一旦被增删元素, HasNext 返回 false, Err 返回 *ConcurrentModificationError
+type CollectionIterator[T any] struct {
+	collection       *Collection[T]
+	index            int
+	expectedModCount int
+	err              error
 }
 
-// 创建一个新的迭代器实例
-func (c *Collection) CreateIterator() Iterator {
-	return &CollectionIterator{
-		collection: c,
-		index:      0,
+// 创建一个新的迭代器实例, 返回具体类型以便调用方在遍历结束后检查 Err
+func (c *Collection[T]) CreateIterator() *CollectionIterator[T] {
+	return &CollectionIterator[T]{
+		collection:       c,
+		index:            0,
+		expectedModCount: c.modCount,
 	}
 }
 
+// 创建一个快照迭代器, 遍历的是创建时元素的副本, 不受之后修改的影响
+func (c *Collection[T]) CreateSnapshotIterator() *CollectionIterator[T] {
+	snapshot := &Collection[T]{data: make([]T, len(c.data))}
+	copy(snapshot.data, c.data)
+	return snapshot.CreateIterator()
+}
+
 // 判断是否还有下一个元素可以访问
-func (it *CollectionIterator) HasNext() bool {
-	return it.index < len(it.collection.data)
+func (it *CollectionIterator[T]) HasNext() bool {
+	return it.valid() && it.index < len(it.collection.data)
+}
+
+// 检查集合是否被修改过, 被修改后迭代器不能再使用
+func (it *CollectionIterator[T]) valid() bool {
+	if it.err == nil && it.collection.modCount != it.expectedModCount {
+		it.err = &ConcurrentModificationError{Expected: it.expectedModCount, Actual: it.collection.modCount}
+	}
+	return it.err == nil
+}
+
+// 迭代因集合被修改而中止时返回的错误, 正常结束时返回 nil
+func (it *CollectionIterator[T]) Err() error {
+	return it.err
 }
 
-// 获取下一个元素
-func (it *CollectionIterator) Next() interface{} {
+// 获取下一个元素, 没有下一个元素时 panic
+func (it *CollectionIterator[T]) Next() T {
 	if !it.HasNext() {
-		return nil
+		panic(it.endErr())
 	}
 	value := it.collection.data[it.index]
 	it.index++
 	return value
 }
 
+// 越界访问时 panic 的错误: 集合被修改时为 *ConcurrentModificationError, 否则为 ErrNoSuchElement
+func (it *CollectionIterator[T]) endErr() error {
+	if it.err != nil {
+		return it.err
+	}
+	return fmt.Errorf("%w: index %d", ErrNoSuchElement, it.index)
+}
+
 // 使用示例
 func main() {
-	collection := &Collection{
-		data: []interface{}{"A", "B", "C", "D", "E"},
-	}
+	collection := NewCollection("A", "B", "C", "D", "E")
 
 	iterator := collection.CreateIterator()
 
@@ -54,4 +149,104 @@ func main() {
 		value := iterator.Next()
 		fmt.Println(value)
 	}
+
+	// range-over-func: 集合直接用于 for range
+	all, _ := collection.All()
+	for i, v := range all {
+		fmt.Print(i, v, " ")
+	}
+	fmt.Println() // 0A 1B 2C 3D 4E
+
+	// 惰性组合: 只会计算真正被消费的元素
+	numbers := NewCollection(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	values, _ := numbers.Values()
+	evens := Filter(values, func(n int) bool { return n%2 == 0 })
+	squares := Map(evens, func(n int) int { return n * n })
+	for chunk := range Chunk(Take(Skip(squares, 1), 3), 2) {
+		fmt.Println(chunk)
+	}
+	// Output:
+	// [16 36]
+	// [64]
+
+	letters, _ := collection.Values()
+	values, _ = numbers.Values()
+	for letter, n := range Zip(letters, Chain(values, Of(100))) {
+		fmt.Print(strings.ToLower(letter), n, " ")
+	}
+	fmt.Println() // a1 b2 c3 d4 e5
+
+	// 迭代过程中修改集合, 迭代器会快速失败
+	fast := collection.CreateIterator()
+	snapshot := collection.CreateSnapshotIterator()
+	fast.Next()
+	collection.Add("F")
+	fmt.Println(fast.HasNext())                                  // false
+	fmt.Println(fast.Err())                                      // iterator: collection modified during iteration (mod count 1, expected 0)
+	fmt.Println(slices.Collect(Seq(snapshot)), collection.Len()) // [A B C D E] 6
+
+	// 以 iter.Seq 遍历时, 遍历结束后从返回的函数得到同样的错误
+	letters, lettersErr := collection.Values()
+	for letter := range letters {
+		if letter == "B" {
+			collection.Remove(0)
+		}
+	}
+	var modified *ConcurrentModificationError
+	fmt.Println(errors.As(lettersErr(), &modified), modified.Expected, modified.Actual) // true 1 2
+	collection.Insert(0, "A")
+	collection.Remove(collection.Len() - 1)
+
+	// 序列可以重复遍历, 每次遍历看到的是遍历开始时的集合, 错误也按最近一次遍历报告
+	fmt.Println(slices.Collect(letters), lettersErr()) // [A B C D E] <nil>
+
+	// 双向、可定位的迭代器
+	cursor := collection.CreateSeekableIterator()
+	cursor.Seek(collection.Len())
+	for cursor.HasPrev() {
+		fmt.Print(cursor.Prev())
+	}
+	fmt.Println() // EDCBA
+	cursor.Seek(2)
+	next, _ := cursor.Peek()
+	fmt.Println(next, cursor.Index(), cursor.Next(), cursor.Prev()) // C 2 C C
+
+	// 零值也是合法元素, 通过 HasNext/Peek 的返回值区分是否到达末尾
+	pointers := NewCollection[*int](nil)
+	it2 := pointers.CreateSeekableIterator()
+	fmt.Println(it2.Next(), it2.HasNext()) // <nil> false
+	_, ok := it2.Peek()
+	fmt.Println(ok) // false
+
+	// 树和图的迭代器同样实现 Iterator, 调用方不需要关心底层结构
+	tree := &BinaryTree[int]{Root: &TreeNode[int]{
+		Value: 4,
+		Left:  &TreeNode[int]{Value: 2, Left: &TreeNode[int]{Value: 1}, Right: &TreeNode[int]{Value: 3}},
+		Right: &TreeNode[int]{Value: 5},
+	}}
+	fmt.Println(slices.Collect(Seq(tree.CreateInOrderIterator())))  // [1 2 3 4 5]
+	fmt.Println(slices.Collect(Seq(tree.CreatePreOrderIterator()))) // [4 2 1 3 5]
+
+	graph := NewGraph[string]()
+	graph.AddEdge("shirt", "tie")
+	graph.AddEdge("tie", "jacket")
+	graph.AddEdge("pants", "shoes")
+	graph.AddEdge("pants", "jacket")
+	fmt.Println(slices.Collect(Seq(graph.CreateBFSIterator("pants")))) // [pants shoes jacket]
+	if order, err := graph.CreateTopologicalIterator(); err == nil {
+		fmt.Println(slices.Collect(Seq(order))) // [shirt pants tie shoes jacket]
+	}
+	graph.AddEdge("jacket", "shirt")
+	fmt.Println(slices.Collect(Seq(graph.CreateDFSIterator("shirt")))) // [shirt tie jacket]
+	_, err := graph.CreateTopologicalIterator()
+	fmt.Println(err) // iterator: graph has a cycle [shirt tie jacket shirt]
+
+	// 迭代器和 iter.Seq 互相转换
+	letters, _ = collection.Values()
+	it := FromSeq(Map(letters, strings.ToLower))
+	defer it.Stop()
+	for it.HasNext() {
+		fmt.Print(it.Next())
+	}
+	fmt.Println() // abcde
 }
@@ -0,0 +1,237 @@
+package main
+
+import "iter"
+
+// Pair 两个值组成的二元组, 用于把 iter.Seq2 转换成单值迭代器
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// Values 以 iter.Seq 的形式返回集合中的所有元素。
+// 序列可以多次遍历, 每次遍历都创建新的迭代器, 因此调用 Values 之后、遍历之前修改集合不影响遍历。
+// 遍历时集合被增删元素, 序列会提前结束; err 返回最近一次遍历的错误, 并发修改时为 *ConcurrentModificationError,
+// 正常结束或还没有遍历时为 nil。同一个序列不能在多个 goroutine 中同时遍历
+func (c *Collection[T]) Values() (seq iter.Seq[T], err func() error) {
+	var last error
+	seq = func(yield func(T) bool) {
+		it := c.CreateIterator()
+		defer func() { last = it.Err() }()
+		for it.HasNext() {
+			if !yield(it.Next()) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return last }
+}
+
+// All 以 iter.Seq2 的形式返回集合中的下标和元素, 遍历和错误处理同 Values
+func (c *Collection[T]) All() (seq iter.Seq2[int, T], err func() error) {
+	var last error
+	seq = func(yield func(int, T) bool) {
+		it := c.CreateIterator()
+		defer func() { last = it.Err() }()
+		for i := 0; it.HasNext(); i++ {
+			if !yield(i, it.Next()) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return last }
+}
+
+// Seq 把迭代器适配成 iter.Seq, 消费时会推进原迭代器, 因此序列只能遍历一次。
+// 迭代器因并发修改等错误提前结束时序列也随之结束, 错误由迭代器自己的 Err 报告
+func Seq[T any](it Iterator[T]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for it.HasNext() {
+			if !yield(it.Next()) {
+				return
+			}
+		}
+	}
+}
+
+// Enumerate 把迭代器适配成带序号的 iter.Seq2, 序号从 0 开始, 错误处理同 Seq
+func Enumerate[T any](it Iterator[T]) iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		for i := 0; it.HasNext(); i++ {
+			if !yield(i, it.Next()) {
+				return
+			}
+		}
+	}
+}
+
+// SeqIterator 由 iter.Seq 转换而来的迭代器。
+// 底层使用 iter.Pull, 提前结束遍历时必须调用 Stop 释放资源
+type SeqIterator[T any] struct {
+	next    func() (T, bool)
+	stop    func()
+	value   T
+	fetched bool
+	ok      bool
+}
+
+// FromSeq 把 iter.Seq 适配成迭代器
+func FromSeq[T any](seq iter.Seq[T]) *SeqIterator[T] {
+	next, stop := iter.Pull(seq)
+	return &SeqIterator[T]{next: next, stop: stop}
+}
+
+// FromSeq2 把 iter.Seq2 适配成元素为 Pair 的迭代器
+func FromSeq2[K, V any](seq iter.Seq2[K, V]) *SeqIterator[Pair[K, V]] {
+	return FromSeq(func(yield func(Pair[K, V]) bool) {
+		for k, v := range seq {
+			if !yield(Pair[K, V]{Key: k, Value: v}) {
+				return
+			}
+		}
+	})
+}
+
+// 判断是否还有下一个元素, 需要预取一个元素
+func (it *SeqIterator[T]) HasNext() bool {
+	if !it.fetched {
+		it.value, it.ok = it.next()
+		it.fetched = true
+	}
+	return it.ok
+}
+
+// 获取下一个元素, 没有下一个元素时 panic
+func (it *SeqIterator[T]) Next() T {
+	var zero T
+	if !it.HasNext() {
+		panic(ErrNoSuchElement)
+	}
+	value := it.value
+	it.value, it.fetched = zero, false
+	return value
+}
+
+// Stop 结束底层序列, 之后 HasNext 总是返回 false
+func (it *SeqIterator[T]) Stop() {
+	it.stop()
+	// 丢弃 HasNext 已经预取的元素
+	var zero T
+	it.value, it.fetched, it.ok = zero, true, false
+}
+
+// Of 由给定元素构成的序列
+func Of[T any](items ...T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for _, item := range items {
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+// Map 对序列中的每个元素做变换
+func Map[T, U any](seq iter.Seq[T], f func(T) U) iter.Seq[U] {
+	return func(yield func(U) bool) {
+		for v := range seq {
+			if !yield(f(v)) {
+				return
+			}
+		}
+	}
+}
+
+// Filter 只保留满足条件的元素
+func Filter[T any](seq iter.Seq[T], keep func(T) bool) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range seq {
+			if keep(v) && !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Take 最多取前 n 个元素, 取够后不再从上游拉取
+func Take[T any](seq iter.Seq[T], n int) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		if n <= 0 {
+			return
+		}
+		taken := 0
+		for v := range seq {
+			if !yield(v) {
+				return
+			}
+			taken++
+			if taken == n {
+				return
+			}
+		}
+	}
+}
+
+// Skip 跳过前 n 个元素
+func Skip[T any](seq iter.Seq[T], n int) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		skipped := 0
+		for v := range seq {
+			if skipped < n {
+				skipped++
+				continue
+			}
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Zip 把两个序列按位置配对, 以较短的序列为准
+func Zip[A, B any](a iter.Seq[A], b iter.Seq[B]) iter.Seq2[A, B] {
+	return func(yield func(A, B) bool) {
+		nextB, stop := iter.Pull(b)
+		defer stop()
+		for va := range a {
+			vb, ok := nextB()
+			if !ok || !yield(va, vb) {
+				return
+			}
+		}
+	}
+}
+
+// Chain 依次连接多个序列
+func Chain[T any](seqs ...iter.Seq[T]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for _, seq := range seqs {
+			for v := range seq {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Chunk 把序列按 size 个元素一组切分, 最后一组可能不足 size 个; size 必须大于 0
+func Chunk[T any](seq iter.Seq[T], size int) iter.Seq[[]T] {
+	if size <= 0 {
+		panic("iterator: chunk size must be positive")
+	}
+	return func(yield func([]T) bool) {
+		chunk := make([]T, 0, size)
+		for v := range seq {
+			chunk = append(chunk, v)
+			if len(chunk) == size {
+				if !yield(chunk) {
+					return
+				}
+				chunk = make([]T, 0, size)
+			}
+		}
+		if len(chunk) > 0 {
+			yield(chunk)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+// 二叉树节点
+type TreeNode[T any] struct {
+	Value       T
+	Left, Right *TreeNode[T]
+}
+
+// 二叉树集合
+type BinaryTree[T any] struct {
+	Root *TreeNode[T]
+}
+
+// 中序遍历迭代器, 用显式栈保存左链, 每次 Next 只展开需要的部分
+type InOrderIterator[T any] struct {
+	stack []*TreeNode[T]
+}
+
+// 创建中序遍历迭代器
+func (t *BinaryTree[T]) CreateInOrderIterator() Iterator[T] {
+	it := &InOrderIterator[T]{}
+	it.pushLeft(t.Root)
+	return it
+}
+
+func (it *InOrderIterator[T]) pushLeft(n *TreeNode[T]) {
+	for ; n != nil; n = n.Left {
+		it.stack = append(it.stack, n)
+	}
+}
+
+func (it *InOrderIterator[T]) HasNext() bool {
+	return len(it.stack) > 0
+}
+
+func (it *InOrderIterator[T]) Next() T {
+	if !it.HasNext() {
+		panic(ErrNoSuchElement)
+	}
+	n := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	it.pushLeft(n.Right)
+	return n.Value
+}
+
+// 前序遍历迭代器
+type PreOrderIterator[T any] struct {
+	stack []*TreeNode[T]
+}
+
+// 创建前序遍历迭代器
+func (t *BinaryTree[T]) CreatePreOrderIterator() Iterator[T] {
+	it := &PreOrderIterator[T]{}
+	if t.Root != nil {
+		it.stack = append(it.stack, t.Root)
+	}
+	return it
+}
+
+func (it *PreOrderIterator[T]) HasNext() bool {
+	return len(it.stack) > 0
+}
+
+func (it *PreOrderIterator[T]) Next() T {
+	if !it.HasNext() {
+		panic(ErrNoSuchElement)
+	}
+	n := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	// 先压右子树, 保证左子树先出栈
+	if n.Right != nil {
+		it.stack = append(it.stack, n.Right)
+	}
+	if n.Left != nil {
+		it.stack = append(it.stack, n.Left)
+	}
+	return n.Value
+}
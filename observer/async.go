@@ -0,0 +1,101 @@
+package main
+
+import "sync"
+
+// OverflowPolicy 异步投递队列满时的处理方式
+type OverflowPolicy int
+
+const (
+	// Block 等待观察者处理, 慢观察者会拖慢 SetState
+	Block OverflowPolicy = iota
+	// DropOldest 丢弃队列中最早的事件
+	DropOldest
+	// DropNewest 丢弃新的事件
+	DropNewest
+)
+
+type AsyncOptions struct {
+	Buffer   int // 每个观察者的队列长度, 丢弃策略下至少为 1
+	Overflow OverflowPolicy
+}
+
+// NewAsyncSubject 创建异步通知的主题。
+// 每个观察者有自己的队列和 goroutine, SetState 只负责投递, 观察者的错误交给 ErrorHandler
+func NewAsyncSubject[E any](state E, options AsyncOptions) *ConcreteSubject[E] {
+	if options.Overflow != Block {
+		// 没有缓冲时队列里没有可以丢弃的事件, DropOldest 会一直空转
+		options.Buffer = max(options.Buffer, 1)
+	}
+	return &ConcreteSubject[E]{state: state, policy: ContinueOnError, async: &options}
+}
+
+// Dropped 因队列已满被丢弃的事件数
+func (s *ConcreteSubject[E]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// 一个观察者的投递队列
+type mailbox[E any] struct {
+	subject *ConcreteSubject[E]
+	events  chan Event[E]
+	mu      sync.Mutex // 发送和关闭互斥, 保证不会向已关闭的通道发送
+	closed  bool
+}
+
+// 调用时持有 s.mu
+func (s *ConcreteSubject[E]) startMailbox(observer Observer[E]) *mailbox[E] {
+	m := &mailbox[E]{subject: s, events: make(chan Event[E], s.async.Buffer)}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		for event := range m.events {
+			if err := observer.Update(event); err != nil {
+				s.handleError(&ObserverError[E]{Observer: observer, Err: err})
+			}
+		}
+	}()
+	return m
+}
+
+func (m *mailbox[E]) send(event Event[E]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	switch m.subject.async.Overflow {
+	case Block:
+		m.events <- event
+		return
+	case DropNewest:
+		select {
+		case m.events <- event:
+		default:
+			m.subject.dropped.Add(1)
+		}
+		return
+	}
+	// 持有锁时只有观察者的 goroutine 会从队列取事件, 腾出位置后一定能放入
+	for {
+		select {
+		case m.events <- event:
+			return
+		default:
+		}
+		select {
+		case <-m.events:
+			m.subject.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// 关闭队列, 观察者处理完剩余的事件后 goroutine 退出
+func (m *mailbox[E]) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.events)
+	}
+}
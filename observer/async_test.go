@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// 观察者处理第一个事件时阻塞, 后续事件只能在长度为 1 的队列中等待
+func TestAsyncOverflow(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		options  AsyncOptions
+		received []int
+		dropped  uint64
+	}{
+		{"drop newest", AsyncOptions{Buffer: 1, Overflow: DropNewest}, []int{1, 2}, 2},
+		{"drop oldest", AsyncOptions{Buffer: 1, Overflow: DropOldest}, []int{1, 4}, 2},
+		{"drop newest without buffer", AsyncOptions{Overflow: DropNewest}, []int{1, 2}, 2},
+		{"drop oldest without buffer", AsyncOptions{Overflow: DropOldest}, []int{1, 4}, 2},
+		{"block", AsyncOptions{Buffer: 3, Overflow: Block}, []int{1, 2, 3, 4}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			subject := NewAsyncSubject(0, test.options)
+			started, release := make(chan struct{}), make(chan struct{})
+			var received []int
+			subject.Register(ObserverFunc[int](func(event Event[int]) error {
+				if len(received) == 0 {
+					close(started)
+					<-release
+				}
+				received = append(received, event.Payload)
+				return nil
+			}))
+			subject.SetState(1)
+			<-started
+			for i := 2; i <= 4; i++ {
+				subject.SetState(i)
+			}
+			close(release)
+			subject.Close()
+			if !slices.Equal(received, test.received) || subject.Dropped() != test.dropped {
+				t.Errorf("received %v, dropped %d; want %v, %d", received, subject.Dropped(), test.received, test.dropped)
+			}
+		})
+	}
+}
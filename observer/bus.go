@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidTopic   = errors.New("observer: invalid topic")
+	ErrInvalidPattern = errors.New("observer: invalid pattern")
+)
+
+// EventFilter 订阅的过滤条件, 返回 false 时不通知观察者
+type EventFilter[E any] func(event Event[E]) bool
+
+// Bus 基于主题的事件总线, 多个发布者和多个订阅者通过主题名解耦。
+// 主题名由点分隔, 例如 orders.eu.created; 订阅的模式中 * 匹配一段, # 匹配零段或多段
+type Bus[E any] struct {
+	mu            sync.RWMutex
+	subscriptions []*Subscription[E] // 修改时整体替换, 发布时可以在锁外遍历
+	policy        FailurePolicy
+	// ContinueOnError 时接收观察者的错误, 可以为 nil, 必须在使用总线之前设置
+	ErrorHandler func(err *ObserverError[E])
+}
+
+func NewBus[E any](policy FailurePolicy) *Bus[E] {
+	return &Bus[E]{policy: policy}
+}
+
+// Subscription 订阅, 通过它取消订阅, 不依赖观察者是否可以比较
+type Subscription[E any] struct {
+	bus      *Bus[E]
+	pattern  string
+	segments []string
+	observer Observer[E]
+	filters  []EventFilter[E]
+}
+
+func (s *Subscription[E]) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe 取消订阅, 可以重复调用
+func (s *Subscription[E]) Unsubscribe() {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i := slices.Index(b.subscriptions, s); i >= 0 {
+		b.subscriptions = slices.Delete(slices.Clone(b.subscriptions), i, i+1)
+	}
+}
+
+func (s *Subscription[E]) matches(event Event[E]) bool {
+	if !matchTopic(s.segments, strings.Split(event.Topic, ".")) {
+		return false
+	}
+	for _, filter := range s.filters {
+		if !filter(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscribe 订阅匹配 pattern 的主题, 事件还要满足所有 filters 才会通知观察者
+func (b *Bus[E]) Subscribe(pattern string, observer Observer[E], filters ...EventFilter[E]) (*Subscription[E], error) {
+	segments, err := splitTopic(pattern, true)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscription[E]{bus: b, pattern: pattern, segments: segments, observer: observer, filters: filters}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, s)
+	return s, nil
+}
+
+// Publish 按订阅的先后顺序通知匹配的观察者, 错误按总线的 FailurePolicy 处理
+func (b *Bus[E]) Publish(topic string, payload E) error {
+	if _, err := splitTopic(topic, false); err != nil {
+		return err
+	}
+	event := Event[E]{Topic: topic, Payload: payload}
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+	var observers []Observer[E]
+	for _, s := range subscriptions {
+		if s.matches(event) {
+			observers = append(observers, s.observer)
+		}
+	}
+	return deliver(event, observers, b.policy, b.ErrorHandler)
+}
+
+// 把主题名或模式拆成段, 只有模式可以包含通配符, 通配符必须独占一段
+func splitTopic(topic string, pattern bool) ([]string, error) {
+	invalid := ErrInvalidTopic
+	if pattern {
+		invalid = ErrInvalidPattern
+	}
+	segments := strings.Split(topic, ".")
+	for _, segment := range segments {
+		wildcard := segment == "*" || segment == "#"
+		switch {
+		case segment == "":
+			return nil, fmt.Errorf("%w: %q has an empty segment", invalid, topic)
+		case wildcard && !pattern:
+			return nil, fmt.Errorf("%w: %q contains a wildcard", invalid, topic)
+		case !wildcard && strings.ContainsAny(segment, "*#"):
+			return nil, fmt.Errorf("%w: wildcard must be a whole segment in %q", invalid, topic)
+		}
+	}
+	return segments, nil
+}
+
+func matchTopic(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// 尝试让 # 匹配 0 到 len(topic) 段
+			for i := 0; i <= len(topic); i++ {
+				if matchTopic(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(topic) == 0 {
+				return false
+			}
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+		}
+		pattern, topic = pattern[1:], topic[1:]
+	}
+	return len(topic) == 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock 通知策略使用的时钟, 测试时可以换成 FakeClock
+type Clock interface {
+	Now() time.Time
+	// AfterFunc 在 d 之后调用 f
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	// Stop 取消计时器, 计时器已经触发或已经取消时返回 false
+	Stop() bool
+}
+
+type realClock struct{}
+
+// RealClock 系统时钟
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock 手动推进的时钟, 计时器在 Advance 的 goroutine 中按到期时间依次触发
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	return true
+}
+
+// Advance 把时间推进 d, 触发期间到期的计时器。计时器的回调中新建的计时器如果也到期, 同样会触发
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		// 取最早到期的计时器, 到期时间相同时先创建的先触发
+		next := -1
+		for i, t := range c.timers {
+			if !t.at.After(end) && (next < 0 || t.at.Before(c.timers[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		t := c.timers[next]
+		c.timers = slices.Delete(c.timers, next, next+1)
+		c.now = t.at
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
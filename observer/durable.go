@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrNoEventLog = errors.New("observer: subject has no event log")
+
+// EventLog 只追加的事件日志, 序号从 1 开始连续递增
+type EventLog[E any] interface {
+	// Append 追加一个事件并分配序号
+	Append(payload E) (Event[E], error)
+	// Since 按序号顺序返回序号不小于 seq 的事件
+	Since(seq uint64) ([]Event[E], error)
+}
+
+// MemoryLog 保存在内存中的事件日志
+type MemoryLog[E any] struct {
+	mu     sync.RWMutex
+	events []Event[E]
+}
+
+func (l *MemoryLog[E]) Append(payload E) (Event[E], error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event := Event[E]{Seq: uint64(len(l.events)) + 1, Payload: payload}
+	l.events = append(l.events, event)
+	return event, nil
+}
+
+func (l *MemoryLog[E]) Since(seq uint64) ([]Event[E], error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return since(l.events, seq), nil
+}
+
+func since[E any](events []Event[E], seq uint64) []Event[E] {
+	start := min(uint64(len(events)), max(seq, 1)-1)
+	return append([]Event[E](nil), events[start:]...)
+}
+
+type logRecord[E any] struct {
+	Seq     uint64 `json:"seq"`
+	Payload E      `json:"payload"`
+}
+
+// FileLog 保存在文件中的事件日志, 每行一个 JSON 记录, 写入后立即落盘。
+// 内存中只保存每个事件在文件中的位置, Since 从文件读取事件
+type FileLog[E any] struct {
+	mu      sync.RWMutex
+	file    *os.File
+	offsets []int64 // offsets[i] 是序号为 i+1 的事件在文件中的位置
+	size    int64   // 文件中完整记录的长度, 新事件从这里开始写
+}
+
+// OpenFileLog 打开或创建日志文件, 崩溃时写到一半的最后一行会被截掉
+func OpenFileLog[E any](path string) (*FileLog[E], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileLog[E]{file: file}
+	if err := l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileLog[E]) load() error {
+	reader := bufio.NewReader(l.file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				if err := l.file.Truncate(l.size); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var record logRecord[E]
+		if err := json.Unmarshal(bytes.TrimSpace(data), &record); err != nil {
+			return fmt.Errorf("observer: event log line %d: %w", line, err)
+		}
+		if record.Seq != uint64(len(l.offsets))+1 {
+			return fmt.Errorf("observer: event log line %d: expected sequence %d, got %d", line, len(l.offsets)+1, record.Seq)
+		}
+		l.offsets = append(l.offsets, l.size)
+		l.size += int64(len(data))
+	}
+	_, err := l.file.Seek(l.size, io.SeekStart)
+	return err
+}
+
+func (l *FileLog[E]) Append(payload E) (Event[E], error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event := Event[E]{Seq: uint64(len(l.offsets)) + 1, Payload: payload}
+	data, err := json.Marshal(logRecord[E]{Seq: event.Seq, Payload: payload})
+	if err != nil {
+		return Event[E]{}, err
+	}
+	data = append(data, '\n')
+	if _, err := l.file.Write(data); err != nil {
+		return Event[E]{}, fmt.Errorf("observer: write event log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return Event[E]{}, fmt.Errorf("observer: sync event log: %w", err)
+	}
+	l.offsets = append(l.offsets, l.size)
+	l.size += int64(len(data))
+	return event, nil
+}
+
+func (l *FileLog[E]) Since(seq uint64) ([]Event[E], error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	start := min(uint64(len(l.offsets)), max(seq, 1)-1)
+	if start == uint64(len(l.offsets)) {
+		return nil, nil
+	}
+	return l.read(l.offsets[start], len(l.offsets)-int(start))
+}
+
+// Last 返回最后一个事件, 只读取最后一行
+func (l *FileLog[E]) Last() (Event[E], bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.offsets) == 0 {
+		return Event[E]{}, false, nil
+	}
+	events, err := l.read(l.offsets[len(l.offsets)-1], 1)
+	if err != nil {
+		return Event[E]{}, false, err
+	}
+	return events[0], true, nil
+}
+
+// 从文件位置 offset 开始读取 n 个事件, 调用时持有 l.mu
+func (l *FileLog[E]) read(offset int64, n int) ([]Event[E], error) {
+	reader := bufio.NewReader(io.NewSectionReader(l.file, offset, l.size-offset))
+	events := make([]Event[E], 0, n)
+	for range n {
+		data, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("observer: read event log: %w", err)
+		}
+		var record logRecord[E]
+		if err := json.Unmarshal(bytes.TrimSpace(data), &record); err != nil {
+			return nil, fmt.Errorf("observer: read event log: %w", err)
+		}
+		events = append(events, Event[E]{Seq: record.Seq, Payload: record.Payload})
+	}
+	return events, nil
+}
+
+func (l *FileLog[E]) Close() error {
+	return l.file.Close()
+}
+
+// NewDurableSubject 创建把每次状态变化写入日志的主题, 初始状态为日志中最后一个事件。
+// 日志实现了 Last 时只读取最后一个事件
+func NewDurableSubject[E any](log EventLog[E], policy FailurePolicy) (*ConcreteSubject[E], error) {
+	s := &ConcreteSubject[E]{policy: policy, log: log}
+	if l, ok := log.(interface {
+		Last() (Event[E], bool, error)
+	}); ok {
+		last, ok, err := l.Last()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.state = last.Payload
+		}
+		return s, nil
+	}
+	events, err := log.Since(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		s.state = events[len(events)-1].Payload
+	}
+	return s, nil
+}
+
+// OffsetStore 保存持久订阅已确认的位置, 观察者进程重启后从这里恢复
+type OffsetStore interface {
+	// Load 返回 name 已确认的最大序号, 没有保存过时返回 0
+	Load(name string) (uint64, error)
+	Save(name string, acked uint64) error
+}
+
+// MemoryOffsets 保存在内存中的确认位置, 适合和 MemoryLog 一起使用
+type MemoryOffsets struct {
+	mu      sync.Mutex
+	offsets map[string]uint64
+}
+
+func (o *MemoryOffsets) Load(name string) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.offsets[name], nil
+}
+
+func (o *MemoryOffsets) Save(name string, acked uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.offsets == nil {
+		o.offsets = make(map[string]uint64)
+	}
+	o.offsets[name] = acked
+	return nil
+}
+
+// FileOffsets 保存在文件中的确认位置, 文件内容为订阅名到序号的 JSON 对象。
+// 写文件时先写临时文件, 落盘后改名并同步所在目录, 崩溃时文件要么是旧的内容要么是新的内容。
+// 两次写文件至少间隔 interval, 期间的 Save 只更新内存, 间隔结束时再写入; 崩溃时丢失的确认位置只会导致事件被重新投递
+type FileOffsets struct {
+	mu       sync.Mutex
+	path     string
+	interval time.Duration
+	offsets  map[string]uint64
+	dirty    bool      // offsets 中有还没有写入文件的确认位置
+	written  time.Time // 上一次写文件的时间
+	timer    *time.Timer
+	err      error // 计时器写文件的错误, 由下一次 Save 或 Flush 返回
+}
+
+// OpenFileOffsets 打开确认位置文件, 文件不存在时在第一次写入时创建。interval 为 0 时每次 Save 都写文件
+func OpenFileOffsets(path string, interval time.Duration) (*FileOffsets, error) {
+	o := &FileOffsets{path: path, interval: interval, offsets: make(map[string]uint64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.offsets); err != nil {
+		return nil, fmt.Errorf("observer: offsets file %s: %w", path, err)
+	}
+	return o, nil
+}
+
+func (o *FileOffsets) Load(name string) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.offsets[name], nil
+}
+
+func (o *FileOffsets) Save(name string, acked uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.offsets[name] = acked
+	o.dirty = true
+	if err := o.err; err != nil {
+		o.err = nil
+		return err
+	}
+	wait := o.interval - time.Since(o.written)
+	if wait <= 0 {
+		return o.write()
+	}
+	if o.timer == nil {
+		o.timer = time.AfterFunc(wait, func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.timer = nil
+			o.err = o.write()
+		})
+	}
+	return nil
+}
+
+// Flush 立即写入还没有写入文件的确认位置
+func (o *FileOffsets) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	err := o.err
+	o.err = nil
+	return errors.Join(err, o.write())
+}
+
+// Close 写入还没有写入文件的确认位置
+func (o *FileOffsets) Close() error {
+	return o.Flush()
+}
+
+// 调用时持有 o.mu
+func (o *FileOffsets) write() error {
+	if !o.dirty {
+		return nil
+	}
+	data, err := json.Marshal(o.offsets)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(o.path, data); err != nil {
+		return fmt.Errorf("observer: save offsets: %w", err)
+	}
+	o.dirty = false
+	o.written = time.Now()
+	return nil
+}
+
+// 通过临时文件原子地替换 path 的内容, 改名后同步目录, 保证改名本身也已落盘
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// DurableSubscription 从日志读取事件的订阅, 保证至少一次投递。
+// 观察者返回 nil 表示确认事件; 返回错误时停止投递, 下一次状态变化或 Redeliver 时从第一个未确认的事件重新投递。
+// 命名的订阅每投递完一批事件把确认位置写入 OffsetStore, 确认位置没有写入的事件在重启后会被重新投递
+type DurableSubscription[E any] struct {
+	subject  *ConcreteSubject[E]
+	observer Observer[E]
+	name     string
+	offsets  OffsetStore // 为 nil 时确认位置只保存在内存中
+	mu       sync.Mutex  // 保证事件按顺序投递
+	acked    uint64
+}
+
+// SubscribeFrom 从序号 from 开始订阅, 先回放日志中已有的事件, 再接收新的事件。
+// 确认位置只保存在内存中, 需要在重启后继续时使用 SubscribeDurable。
+// 返回的错误是回放时观察者的错误, 订阅仍然有效
+func (s *ConcreteSubject[E]) SubscribeFrom(from uint64, observer Observer[E]) (*DurableSubscription[E], error) {
+	if s.log == nil {
+		return nil, ErrNoEventLog
+	}
+	return s.subscribe(&DurableSubscription[E]{subject: s, observer: observer, acked: max(from, 1) - 1})
+}
+
+// SubscribeDurable 以 name 订阅, 从 offsets 中保存的位置之后继续投递, 第一次订阅时从头回放。
+// 观察者重启后用同一个 name 和 offsets 订阅即可继续, 不会漏掉重启期间的事件
+func (s *ConcreteSubject[E]) SubscribeDurable(name string, offsets OffsetStore, observer Observer[E]) (*DurableSubscription[E], error) {
+	if s.log == nil {
+		return nil, ErrNoEventLog
+	}
+	acked, err := offsets.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.subscribe(&DurableSubscription[E]{subject: s, observer: observer, name: name, offsets: offsets, acked: acked})
+}
+
+func (s *ConcreteSubject[E]) subscribe(d *DurableSubscription[E]) (*DurableSubscription[E], error) {
+	s.Register(d)
+	return d, d.Redeliver()
+}
+
+// Update 由主题调用, 事件已经在日志中, 从日志按顺序补齐所有未确认的事件
+func (d *DurableSubscription[E]) Update(Event[E]) error {
+	return d.Redeliver()
+}
+
+// Redeliver 投递所有未确认的事件
+func (d *DurableSubscription[E]) Redeliver() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	events, err := d.subject.log.Since(d.acked + 1)
+	if err != nil {
+		return err
+	}
+	acked := d.acked
+	var updateErr error
+	for _, event := range events {
+		if err := d.observer.Update(event); err != nil {
+			updateErr = fmt.Errorf("event %d: %w", event.Seq, err)
+			break
+		}
+		d.acked = event.Seq
+	}
+	// 一批事件只保存一次确认位置
+	if d.offsets != nil && d.acked != acked {
+		if err := d.offsets.Save(d.name, d.acked); err != nil {
+			return errors.Join(updateErr, fmt.Errorf("event %d: %w", d.acked, err))
+		}
+	}
+	return updateErr
+}
+
+// Acked 已确认的最大序号, 之前的事件都已确认
+func (d *DurableSubscription[E]) Acked() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.acked
+}
+
+func (d *DurableSubscription[E]) Close() {
+	d.subject.Deregister(d)
+}
+
+func (d *DurableSubscription[E]) String() string {
+	if d.name != "" {
+		return fmt.Sprintf("durable %s(%v)", d.name, d.observer)
+	}
+	return fmt.Sprintf("durable(%v)", d.observer)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// 重新打开后按序号从文件读取事件, 写到一半的最后一行被截掉
+func TestFileLogSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	log, err := OpenFileLog[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		if _, err := log.Append(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.file.WriteString(`{"seq":4,"pay`)
+	log.Close()
+
+	log, err = OpenFileLog[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if _, err := log.Append("d"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		since uint64
+		want  []string
+	}{
+		{0, []string{"a", "b", "c", "d"}},
+		{3, []string{"c", "d"}},
+		{4, []string{"d"}},
+		{5, nil},
+	} {
+		events, err := log.Since(test.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for i, event := range events {
+			if event.Seq != max(test.since, 1)+uint64(i) {
+				t.Errorf("Since(%d): event %d has seq %d", test.since, i, event.Seq)
+			}
+			got = append(got, event.Payload)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("Since(%d) = %v, want %v", test.since, got, test.want)
+		}
+	}
+	last, ok, err := log.Last()
+	if err != nil || !ok || last.Seq != 4 || last.Payload != "d" {
+		t.Errorf("Last() = %v, %v, %v; want #4 d", last, ok, err)
+	}
+}
+
+// 间隔内的 Save 只更新内存, Flush 后写入文件
+func TestFileOffsetsThrottle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsets.json")
+	offsets, err := OpenFileOffsets(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := func() uint64 {
+		t.Helper()
+		reopened, err := OpenFileOffsets(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acked, _ := reopened.Load("printer")
+		return acked
+	}
+	offsets.Save("printer", 1)
+	offsets.Save("printer", 2)
+	if got := saved(); got != 1 {
+		t.Errorf("before Flush: saved %d, want 1", got)
+	}
+	if got, _ := offsets.Load("printer"); got != 2 {
+		t.Errorf("Load = %d, want 2", got)
+	}
+	if err := offsets.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := saved(); got != 2 {
+		t.Errorf("after Flush: saved %d, want 2", got)
+	}
+}
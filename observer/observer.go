@@ -1,73 +1,326 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event 事件, Payload 为类型化的数据
+type Event[E any] struct {
+	Topic   string // 事件总线上的主题名, 主题直接通知时为空
+	Seq     uint64 // 事件日志中的序号, 没有日志时为 0
+	Payload E
+}
 
 // Subject 主题接口
-type Subject interface {
-	Register(observer Observer)
-	Deregister(observer Observer)
-	NotifyAll()
+type Subject[E any] interface {
+	Register(observer Observer[E])
+	Deregister(observer Observer[E])
+	NotifyAll() error
+}
+
+// Observer 观察者接口, 返回的错误按主题的 FailurePolicy 处理
+type Observer[E any] interface {
+	Update(event Event[E]) error
 }
 
-// Observer 观察者接口
-type Observer interface {
-	Update(subject Subject)
+// ObserverFunc 把函数适配为观察者。
+// 函数不能比较, Deregister 对它不起作用, 需要注销时使用 RegisterWith 返回的 Registration 或 Bus 的订阅
+type ObserverFunc[E any] func(event Event[E]) error
+
+func (f ObserverFunc[E]) Update(event Event[E]) error {
+	return f(event)
+}
+
+// ObserverError 某个观察者处理事件失败
+type ObserverError[E any] struct {
+	Observer Observer[E]
+	Err      error
 }
 
-// ConcreteSubject 具体主题
-type ConcreteSubject struct {
-	observers []Observer
-	state     string
+func (e *ObserverError[E]) Error() string {
+	return fmt.Sprintf("observer %v: %v", e.Observer, e.Err)
 }
 
-func (s *ConcreteSubject) Register(observer Observer) {
-	s.observers = append(s.observers, observer)
+func (e *ObserverError[E]) Unwrap() error {
+	return e.Err
+}
+
+// FailurePolicy 观察者返回错误时主题的处理方式
+type FailurePolicy int
+
+const (
+	// ContinueOnError 继续通知其余观察者, 错误交给 ErrorHandler, 通知本身不返回错误。
+	// 异步模式下总是使用这种方式
+	ContinueOnError FailurePolicy = iota
+	// StopOnError 不再通知其余观察者, 返回第一个错误
+	StopOnError
+	// CollectErrors 通知所有观察者, 返回所有错误
+	CollectErrors
+)
+
+var ErrSubjectClosed = errors.New("observer: subject closed")
+
+// ConcreteSubject 具体主题, 可以被多个 goroutine 同时使用。
+// 同步模式下在 SetState 的 goroutine 中依次通知观察者, 通知时不持有锁,
+// 观察者可以在 Update 中注册或注销观察者
+type ConcreteSubject[E any] struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber[E]
+	state       E
+	policy      FailurePolicy
+	closed      bool
+	// ContinueOnError 和异步模式下接收观察者的错误, 可以为 nil, 必须在使用主题之前设置
+	ErrorHandler func(err *ObserverError[E])
+
+	async   *AsyncOptions // 为 nil 时同步通知
+	workers sync.WaitGroup
+	dropped atomic.Uint64
+
+	log EventLog[E] // 不为 nil 时每次状态变化先写入日志
+
+	notification NotificationPolicy[E] // 不为 nil 时状态变化交给策略决定何时通知
 }
 
-func (s *ConcreteSubject) Deregister(observer Observer) {
-	for i, ob := range s.observers {
-		if ob == observer {
-			s.observers = append(s.observers[:i], s.observers[i+1:]...)
+type subscriber[E any] struct {
+	observer Observer[E]
+	mailbox  *mailbox[E] // 异步模式下的投递队列
+}
+
+func NewConcreteSubject[E any](state E, policy FailurePolicy) *ConcreteSubject[E] {
+	return &ConcreteSubject[E]{state: state, policy: policy}
+}
+
+func (s *ConcreteSubject[E]) Register(observer Observer[E]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	sub := &subscriber[E]{observer: observer}
+	if s.async != nil {
+		sub.mailbox = s.startMailbox(observer)
+	}
+	s.subscribers = append(s.subscribers, sub)
+}
+
+// Deregister 注销观察者。不能比较的观察者(如 ObserverFunc)无法找到, 调用不起作用
+func (s *ConcreteSubject[E]) Deregister(observer Observer[E]) {
+	if observer == nil || !reflect.ValueOf(observer).Comparable() {
+		// 不可比较的值(包括含有函数字段的结构体)用 == 比较会 panic
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subscribers {
+		if sub.observer == observer {
+			s.subscribers = slices.Delete(slices.Clone(s.subscribers), i, i+1)
+			if sub.mailbox != nil {
+				// 观察者可能在自己的 Update 中注销, 不能在这里等待投递队列
+				go sub.mailbox.close()
+			}
 			break
 		}
 	}
 }
 
-func (s *ConcreteSubject) NotifyAll() {
-	for _, observer := range s.observers {
-		observer.Update(s)
+// NotifyAll 把当前状态通知所有观察者
+func (s *ConcreteSubject[E]) NotifyAll() error {
+	s.mu.RLock()
+	event, subscribers, closed := Event[E]{Payload: s.state}, s.subscribers, s.closed
+	s.mu.RUnlock()
+	if closed {
+		return ErrSubjectClosed
+	}
+	return s.notify(event, subscribers)
+}
+
+// subscribers 在修改时整体替换, 不会被原地修改, 因此可以在锁外遍历
+func (s *ConcreteSubject[E]) notify(event Event[E], subscribers []*subscriber[E]) error {
+	if s.async != nil {
+		for _, sub := range subscribers {
+			sub.mailbox.send(event)
+		}
+		return nil
+	}
+	observers := make([]Observer[E], len(subscribers))
+	for i, sub := range subscribers {
+		observers[i] = sub.observer
+	}
+	return deliver(event, observers, s.policy, s.ErrorHandler)
+}
+
+// SetNotificationPolicy 设置通知策略, 必须在使用主题之前设置。
+// 之后 SetState 不再直接通知观察者, 观察者的错误总是交给 ErrorHandler
+func (s *ConcreteSubject[E]) SetNotificationPolicy(policy NotificationPolicy[E]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notification = policy
+}
+
+// 通知策略发出事件, 主题关闭后 subscribers 为空, 过期的计时器不会再通知
+func (s *ConcreteSubject[E]) emit(events []Event[E]) {
+	s.mu.RLock()
+	subscribers := s.subscribers
+	s.mu.RUnlock()
+	s.emitTo(subscribers, events)
+}
+
+func (s *ConcreteSubject[E]) emitTo(subscribers []*subscriber[E], events []Event[E]) {
+	if s.async != nil {
+		for _, event := range events {
+			for _, sub := range subscribers {
+				sub.mailbox.send(event)
+			}
+		}
+		return
+	}
+	for _, sub := range subscribers {
+		if batch, ok := sub.observer.(BatchObserver[E]); ok {
+			if err := batch.UpdateBatch(events); err != nil {
+				s.handleError(&ObserverError[E]{Observer: sub.observer, Err: err})
+			}
+			continue
+		}
+		for _, event := range events {
+			deliver(event, []Observer[E]{sub.observer}, ContinueOnError, s.ErrorHandler)
+		}
 	}
 }
 
-func (s *ConcreteSubject) SetState(state string) {
+func (s *ConcreteSubject[E]) handleError(err *ObserverError[E]) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
+
+// 依次通知观察者, 按 policy 处理错误, handler 可以为 nil
+func deliver[E any](event Event[E], observers []Observer[E], policy FailurePolicy, handler func(*ObserverError[E])) error {
+	var errs []error
+	for _, observer := range observers {
+		err := observer.Update(event)
+		if err == nil {
+			continue
+		}
+		obErr := &ObserverError[E]{Observer: observer, Err: err}
+		switch policy {
+		case StopOnError:
+			return obErr
+		case CollectErrors:
+			errs = append(errs, obErr)
+		default:
+			if handler != nil {
+				handler(obErr)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ConcreteSubject[E]) SetState(state E) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSubjectClosed
+	}
+	event := Event[E]{Payload: state}
+	if s.log != nil {
+		// 在锁内写日志, 保证日志的顺序和状态变化的顺序一致
+		logged, err := s.log.Append(state)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		event = logged
+	}
 	s.state = state
-	s.NotifyAll()
+	subscribers, notification := s.subscribers, s.notification
+	s.mu.Unlock()
+	if notification != nil {
+		notification.Submit(event, s.emit)
+		return nil
+	}
+	return s.notify(event, subscribers)
 }
 
-func (s *ConcreteSubject) GetState() string {
+func (s *ConcreteSubject[E]) GetState() E {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.state
 }
 
+// Close 关闭主题, 之后的 SetState 返回 ErrSubjectClosed。
+// 通知策略中等待的事件立即发出; 异步模式下等待所有观察者处理完已经投递的事件, 不能在观察者的 Update 中调用
+func (s *ConcreteSubject[E]) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	subscribers, notification := s.subscribers, s.notification
+	s.subscribers = nil
+	s.mu.Unlock()
+	if notification != nil {
+		notification.Flush(func(events []Event[E]) { s.emitTo(subscribers, events) })
+	}
+	for _, sub := range subscribers {
+		if sub.mailbox != nil {
+			sub.mailbox.close()
+		}
+	}
+	s.workers.Wait()
+}
+
 // ConcreteObserver 具体观察者
-type ConcreteObserver struct {
+type ConcreteObserver[E any] struct {
 	id    string
-	state string
+	state E
 }
 
-func (o *ConcreteObserver) Update(subject Subject) {
-	s, ok := subject.(*ConcreteSubject)
-	if ok {
-		o.state = s.GetState()
-		fmt.Printf("Observer %s state updated to %s\n", o.id, o.state)
+func (o *ConcreteObserver[E]) Update(event Event[E]) error {
+	o.state = event.Payload
+	fmt.Printf("Observer %s state updated to %v\n", o.id, o.state)
+	return nil
+}
+
+func (o *ConcreteObserver[E]) String() string {
+	return o.id
+}
+
+// 只接受正数的观察者, 用来演示错误处理
+type PositiveObserver struct {
+	id string
+}
+
+func (o *PositiveObserver) Update(event Event[int]) error {
+	if event.Payload <= 0 {
+		return fmt.Errorf("state %d is not positive", event.Payload)
 	}
+	fmt.Printf("Observer %s accepted %d\n", o.id, event.Payload)
+	return nil
+}
+
+func (o *PositiveObserver) String() string {
+	return o.id
 }
 
 // 使用示例
 func main() {
-	subject := &ConcreteSubject{state: "Initial state"}
+	subject := NewConcreteSubject("Initial state", ContinueOnError)
 
-	observer1 := &ConcreteObserver{id: "Observer 1"}
-	observer2 := &ConcreteObserver{id: "Observer 2"}
+	observer1 := &ConcreteObserver[string]{id: "Observer 1"}
+	observer2 := &ConcreteObserver[string]{id: "Observer 2"}
 
 	subject.Register(observer1)
 	subject.Register(observer2)
@@ -82,4 +335,303 @@ func main() {
 	subject.SetState("Another state")
 	// Output:
 	// Observer Observer 1 state updated to Another state
+
+	// 函数观察者不能比较, Deregister 不起作用, 但也不会 panic
+	audit := ObserverFunc[string](func(event Event[string]) error {
+		fmt.Println("audit:", event.Payload)
+		return nil
+	})
+	subject.Register(audit)
+	subject.Deregister(audit)
+	subject.SetState("Final state")
+	// Output:
+	// Observer Observer 1 state updated to Final state
+	// audit: Final state
+
+	// 观察者返回错误时的三种处理方式
+	for _, policy := range []FailurePolicy{ContinueOnError, StopOnError, CollectErrors} {
+		counter := NewConcreteSubject(0, policy)
+		counter.ErrorHandler = func(err *ObserverError[int]) {
+			fmt.Println("handled:", err)
+		}
+		counter.Register(&PositiveObserver{id: "A"})
+		counter.Register(&PositiveObserver{id: "B"})
+		fmt.Println("returned:", counter.SetState(-1))
+	}
+	// Output:
+	// handled: observer A: state -1 is not positive
+	// handled: observer B: state -1 is not positive
+	// returned: <nil>
+	// returned: observer A: state -1 is not positive
+	// returned: observer A: state -1 is not positive
+	// observer B: state -1 is not positive
+
+	// 异步通知: 慢观察者不会阻塞 SetState, 队列满时丢弃最早的事件
+	async := NewAsyncSubject(0, AsyncOptions{Buffer: 2, Overflow: DropOldest})
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := &SlowObserver{started: started, release: release}
+	async.Register(slow)
+	async.SetState(1)
+	<-started // 观察者正在处理 1, 后面的事件进入队列
+	for i := 2; i <= 5; i++ {
+		async.SetState(i)
+	}
+	close(release)
+	async.Close() // 等待观察者处理完队列中的事件
+	fmt.Println(slow.received, async.Dropped(), async.SetState(6))
+	// Output: [1 4 5] 2 observer: subject closed
+
+	// 事件总线: 按主题订阅, * 匹配一段, # 匹配任意多段
+	bus := NewBus[Order](ContinueOnError)
+	logger := func(name string) Observer[Order] {
+		return ObserverFunc[Order](func(event Event[Order]) error {
+			fmt.Printf("%s <- %s %s\n", name, event.Topic, event.Payload.ID)
+			return nil
+		})
+	}
+	bus.Subscribe("orders.*", logger("orders.*"))
+	all, _ := bus.Subscribe("orders.#", logger("orders.#"))
+	bus.Subscribe("*.*.created", logger("large eu"), func(event Event[Order]) bool {
+		return event.Payload.Amount > 100
+	})
+	bus.Publish("orders.created", Order{ID: "A1", Amount: 50})
+	bus.Publish("orders.eu.created", Order{ID: "B2", Amount: 500})
+	bus.Publish("orders.eu.created", Order{ID: "B3", Amount: 20})
+	all.Unsubscribe()
+	bus.Publish("orders.shipped", Order{ID: "A1", Amount: 50})
+	_, err := bus.Subscribe("orders.c*", logger("bad"))
+	fmt.Println(err)
+	// Output:
+	// orders.* <- orders.created A1
+	// orders.# <- orders.created A1
+	// orders.# <- orders.eu.created B2
+	// large eu <- orders.eu.created B2
+	// orders.# <- orders.eu.created B3
+	// orders.* <- orders.shipped A1
+	// observer: invalid pattern: wildcard must be a whole segment in "orders.c*"
+
+	// 持久化: 状态变化写入日志, 命名的订阅从保存的确认位置回放, 处理失败的事件会被重新投递
+	dir, _ := os.MkdirTemp("", "events")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prices.log")
+	log, _ := OpenFileLog[int](path)
+	prices, _ := NewDurableSubject[int](log, ContinueOnError)
+	prices.SetState(100)
+	prices.SetState(105)
+	prices.SetState(98)
+
+	failOnce := true
+	printer := ObserverFunc[int](func(event Event[int]) error {
+		if event.Payload > 110 && failOnce {
+			failOnce = false
+			return errors.New("temporarily unavailable")
+		}
+		fmt.Printf("#%d %d\n", event.Seq, event.Payload)
+		return nil
+	})
+	offsetsPath := filepath.Join(dir, "offsets.json")
+	offsets, _ := OpenFileOffsets(offsetsPath, time.Second)
+	sub, _ := prices.SubscribeDurable("printer", offsets, printer) // 第一次订阅, 回放 #1 到 #3
+	prices.SetState(120)                                           // 处理失败, 没有确认
+	prices.SetState(115)                                           // 先重新投递 #4, 再投递 #5
+	fmt.Println("acked", sub.Acked())
+	offsets.Close() // 一秒内的确认位置还没有写入文件, 关闭时写入
+	log.Close()
+
+	// 重启后日志和确认位置都从文件恢复, 观察者从上次确认的位置继续
+	log, _ = OpenFileLog[int](path)
+	defer log.Close()
+	prices, _ = NewDurableSubject[int](log, ContinueOnError)
+	prices.SetState(90)
+	offsets, _ = OpenFileOffsets(offsetsPath, time.Second)
+	defer offsets.Close()
+	prices.SubscribeDurable("printer", offsets, printer)
+	fmt.Println(prices.GetState())
+	// Output:
+	// #1 100
+	// #2 105
+	// #3 98
+	// #4 120
+	// #5 115
+	// acked 5
+	// #6 90
+	// 90
+
+	// 自动注销: Context 取消、第一次通知后、只在满足条件时通知, 以及弱引用
+	temperature := NewConcreteSubject(20, ContinueOnError)
+	ctx, cancel := context.WithCancel(context.Background())
+	scoped := temperature.RegisterWith(&ConcreteObserver[int]{id: "scoped"}, RegisterOptions[int]{Context: ctx})
+	once := temperature.RegisterWith(&ConcreteObserver[int]{id: "once"}, RegisterOptions[int]{Once: true})
+	temperature.RegisterWith(&ConcreteObserver[int]{id: "alarm"}, RegisterOptions[int]{When: func(t int) bool { return t > 30 }})
+	weakObserver := &ConcreteObserver[int]{id: "weak"}
+	weakly := RegisterWeak(temperature, weakObserver, RegisterOptions[int]{})
+	temperature.SetState(25)
+	cancel()
+	weakObserver = nil
+	runtime.GC()
+	temperature.SetState(35)
+	fmt.Println(scoped.Active(), once.Active(), weakly.Active())
+	// Output:
+	// Observer scoped state updated to 25
+	// Observer once state updated to 25
+	// Observer weak state updated to 25
+	// Observer alarm state updated to 35
+	// false false false
+
+	// 通知策略: 用 FakeClock 控制时间。防抖只通知停止输入后的最新状态
+	clock := NewFakeClock(time.Time{})
+	search := NewConcreteSubject("", ContinueOnError)
+	search.SetNotificationPolicy(Debounce[string](300*time.Millisecond, clock))
+	search.Register(&ConcreteObserver[string]{id: "debounced"})
+	for _, query := range []string{"g", "go", "gop"} {
+		search.SetState(query)
+		clock.Advance(100 * time.Millisecond)
+	}
+	clock.Advance(300 * time.Millisecond)
+	// Output:
+	// Observer debounced state updated to gop
+
+	// 节流: 每秒最多两次, 超出的只保留最新状态, 在下一秒开始时通知
+	ticks := NewConcreteSubject(0, ContinueOnError)
+	ticks.SetNotificationPolicy(Throttle[int](2, time.Second, clock))
+	ticks.Register(&ConcreteObserver[int]{id: "throttled"})
+	for i := 1; i <= 5; i++ {
+		ticks.SetState(i)
+	}
+	clock.Advance(time.Second)
+	// Output:
+	// Observer throttled state updated to 1
+	// Observer throttled state updated to 2
+	// Observer throttled state updated to 5
+
+	// 合并: 第一次变化 500ms 后通知最新状态, Close 时立即通知等待中的状态
+	gauge := NewConcreteSubject(0, ContinueOnError)
+	gauge.SetNotificationPolicy(Coalesce[int](500*time.Millisecond, clock))
+	gauge.Register(&ConcreteObserver[int]{id: "coalesced"})
+	for i := 1; i <= 3; i++ {
+		gauge.SetState(i)
+		clock.Advance(200 * time.Millisecond)
+	}
+	gauge.SetState(4)
+	gauge.Close()
+	// Output:
+	// Observer coalesced state updated to 3
+	// Observer coalesced state updated to 4
+
+	// 批量: 攒够 3 个或等待 1 秒后一次通知, BatchObserver 收到整批事件
+	changes := NewConcreteSubject(0, ContinueOnError)
+	changes.SetNotificationPolicy(Batch[int](3, time.Second, clock))
+	changes.Register(BatchPrinter{})
+	changes.Register(&ConcreteObserver[int]{id: "single"})
+	for i := 1; i <= 4; i++ {
+		changes.SetState(i)
+	}
+	clock.Advance(time.Second)
+	// Output:
+	// batch [1 2 3]
+	// Observer single state updated to 1
+	// Observer single state updated to 2
+	// Observer single state updated to 3
+	// batch [4]
+	// Observer single state updated to 4
+
+	// 运算符: 从已有的主题派生新的主题
+	price := NewConcreteSubject(100, ContinueOnError)
+	volume := NewConcreteSubject(10, ContinueOnError)
+	show := func(name string) Observer[string] {
+		return ObserverFunc[string](func(event Event[string]) error {
+			fmt.Println(name, event.Payload)
+			return nil
+		})
+	}
+	distinct := DistinctUntilChanged(price)
+	label := Map(distinct, func(p int) string { return fmt.Sprintf("$%d", p) })
+	label.Register(show("price"))
+	rising := Filter(price, func(p int) bool { return p > 100 })
+	high := Scan(rising, 0, func(high, p int) int { return max(high, p) })
+	Map(high, strconv.Itoa).Register(show("high"))
+	turnover := CombineLatest(price, volume, func(p, v int) int { return p * v })
+	Map(turnover, strconv.Itoa).Register(show("turnover"))
+	pairs := Buffer(Merge[int](price, volume), 2)
+	pairs.Register(ObserverFunc[[]int](func(event Event[[]int]) error {
+		fmt.Println("pair", event.Payload)
+		return nil
+	}))
+	price.SetState(105)
+	price.SetState(105)
+	volume.SetState(20)
+	price.SetState(99)
+	label.Close() // distinct 没有其他观察者, 随之关闭并从 price 注销
+	price.SetState(110)
+	pairs.Close()
+	fmt.Println(distinct.SetState(0))
+	// Output:
+	// price $105
+	// high 105
+	// turnover 1050
+	// high 105
+	// turnover 1050
+	// pair [105 105]
+	// turnover 2100
+	// price $99
+	// turnover 1980
+	// pair [20 99]
+	// high 110
+	// turnover 2200
+	// pair [110]
+	// observer: subject closed
+
+	// Window 按时间分组, ToChannel 把主题接到通道上
+	clicks := NewConcreteSubject(0, ContinueOnError)
+	windows, stop := context.WithCancel(context.Background())
+	counts := ToChannel(windows, Map(Window(clicks, time.Second, clock), func(w []int) int { return len(w) }), 4)
+	for i := 1; i <= 3; i++ {
+		clicks.SetState(i)
+	}
+	clock.Advance(time.Second)
+	clicks.SetState(4)
+	clock.Advance(2 * time.Second)
+	stop()
+	for count := range counts {
+		fmt.Println("clicks per window:", count)
+	}
+	// Output:
+	// clicks per window: 3
+	// clicks per window: 1
+}
+
+type Order struct {
+	ID     string
+	Amount int
+}
+
+// 处理第一个事件时等待 release 的观察者
+type SlowObserver struct {
+	started, release chan struct{}
+	received         []int
+}
+
+func (o *SlowObserver) Update(event Event[int]) error {
+	if len(o.received) == 0 {
+		close(o.started)
+		<-o.release
+	}
+	o.received = append(o.received, event.Payload)
+	return nil
+}
+
+// 整批打印事件的观察者
+type BatchPrinter struct{}
+
+func (BatchPrinter) Update(event Event[int]) error {
+	return BatchPrinter{}.UpdateBatch([]Event[int]{event})
+}
+
+func (BatchPrinter) UpdateBatch(events []Event[int]) error {
+	payloads := make([]int, len(events))
+	for i, event := range events {
+		payloads[i] = event.Payload
+	}
+	fmt.Println("batch", payloads)
+	return nil
 }
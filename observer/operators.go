@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stream 由其他主题派生的主题, 来源的状态变化经过运算符后成为它的状态变化。
+// Stream 本身也是主题, 可以继续派生; 同步通知, 观察者的错误交给 ErrorHandler。
+// 来源没有当前状态时 Stream 在第一次变化之前也没有状态, GetState 返回零值。
+// 派生的 Stream 关闭后, 作为来源的 Stream 如果没有其他观察者也随之关闭,
+// 因此关闭链条末端的 Stream 就会从根主题上注销整条链; 还要继续使用的中间 Stream 需要先注册自己的观察者
+type Stream[E any] struct {
+	*ConcreteSubject[E]
+	mu     sync.Mutex
+	detach []func()
+	unset  atomic.Bool // 还没有状态, GetState 返回零值, 派生时不把它当作当前状态
+}
+
+func newStream[E any](state E) *Stream[E] {
+	return &Stream[E]{ConcreteSubject: NewConcreteSubject(state, ContinueOnError)}
+}
+
+// Close 从所有来源注销, 再关闭主题。Buffer 和 Window 中未满的一批在关闭前发出
+func (s *Stream[E]) Close() {
+	s.mu.Lock()
+	detach := s.detach
+	s.detach = nil
+	s.mu.Unlock()
+	for _, f := range detach {
+		f()
+	}
+	s.ConcreteSubject.Close()
+}
+
+// 最后一个派生的 Stream 关闭后调用, 没有观察者时关闭自己
+func (s *Stream[E]) release() {
+	s.ConcreteSubject.mu.RLock()
+	idle := len(s.subscribers) == 0
+	s.ConcreteSubject.mu.RUnlock()
+	if idle {
+		s.Close()
+	}
+}
+
+// 来源是 Stream 时注销后调用 release
+func release[E any](source Subject[E]) {
+	if s, ok := source.(interface{ release() }); ok {
+		s.release()
+	}
+}
+
+func (s *Stream[E]) SetState(state E) error {
+	s.unset.Store(false)
+	return s.ConcreteSubject.SetState(state)
+}
+
+// 当前状态, Stream 还没有状态时 ok 为 false
+func (s *Stream[E]) current() (state E, ok bool) {
+	return s.GetState(), !s.unset.Load()
+}
+
+func (s *Stream[E]) onClose(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detach = append(s.detach, f)
+}
+
+// 注册在来源上的观察者, 用指针注册才能注销
+type operator[E any] struct {
+	update func(event Event[E]) error
+}
+
+func (o *operator[E]) Update(event Event[E]) error {
+	return o.update(event)
+}
+
+// 订阅来源, Stream 关闭时注销, 并释放作为来源的 Stream
+func subscribe[E, R any](stream *Stream[R], source Subject[E], update func(event Event[E]) error) {
+	o := &operator[E]{update: update}
+	source.Register(o)
+	stream.onClose(func() {
+		source.Deregister(o)
+		release(source)
+	})
+}
+
+// 来源实现了 GetState 并且已经有状态时返回它的当前状态
+func stateOf[E any](source Subject[E]) (E, bool) {
+	if s, ok := source.(interface{ current() (E, bool) }); ok {
+		return s.current()
+	}
+	if s, ok := source.(interface{ GetState() E }); ok {
+		return s.GetState(), true
+	}
+	var zero E
+	return zero, false
+}
+
+// Map 把来源的每个状态转换为 f 的结果
+func Map[E, R any](source Subject[E], f func(E) R) *Stream[R] {
+	var state R
+	current, ok := stateOf(source)
+	if ok {
+		state = f(current)
+	}
+	out := newStream(state)
+	out.unset.Store(!ok)
+	subscribe(out, source, func(event Event[E]) error {
+		return out.SetState(f(event.Payload))
+	})
+	return out
+}
+
+// Filter 只保留满足 keep 的状态。
+// 来源的当前状态不满足 keep 时 Stream 在第一个满足的状态之前没有状态, GetState 返回零值, 由它派生的运算符不会使用这个零值
+func Filter[E any](source Subject[E], keep func(E) bool) *Stream[E] {
+	current, ok := stateOf(source)
+	ok = ok && keep(current)
+	if !ok {
+		var zero E
+		current = zero
+	}
+	out := newStream(current)
+	out.unset.Store(!ok)
+	subscribe(out, source, func(event Event[E]) error {
+		if !keep(event.Payload) {
+			return nil
+		}
+		return out.SetState(event.Payload)
+	})
+	return out
+}
+
+// Scan 用 f 累积来源的状态变化, 每次变化后通知累积的结果
+func Scan[E, R any](source Subject[E], seed R, f func(acc R, state E) R) *Stream[R] {
+	out := newStream(seed)
+	var seq sequencer[R] // 来源可能被并发通知, 保证累积的顺序和通知的顺序一致
+	acc := seed
+	subscribe(out, source, func(event Event[E]) error {
+		seq.mu.Lock()
+		acc = f(acc, event.Payload)
+		return seq.setUnlock(out, acc)
+	})
+	return out
+}
+
+// Merge 把多个来源的状态变化合并为一个主题, 状态为最近一次变化
+func Merge[E any](sources ...Subject[E]) *Stream[E] {
+	var state E
+	known := false
+	if len(sources) > 0 {
+		state, known = stateOf(sources[0])
+	}
+	out := newStream(state)
+	out.unset.Store(!known)
+	for _, source := range sources {
+		subscribe(out, source, func(event Event[E]) error {
+			return out.SetState(event.Payload)
+		})
+	}
+	return out
+}
+
+// CombineLatest 任一来源变化时, 用两个来源的最新状态计算新状态。
+// 来源没有 GetState 时, 要等它第一次变化之后才开始通知
+func CombineLatest[A, B, R any](a Subject[A], b Subject[B], f func(A, B) R) *Stream[R] {
+	var seq sequencer[R]
+	latestA, knownA := stateOf(a)
+	latestB, knownB := stateOf(b)
+	var state R
+	if knownA && knownB {
+		state = f(latestA, latestB)
+	}
+	out := newStream(state)
+	out.unset.Store(!knownA || !knownB)
+	subscribe(out, a, func(event Event[A]) error {
+		seq.mu.Lock()
+		latestA, knownA = event.Payload, true
+		if !knownB {
+			seq.mu.Unlock()
+			return nil
+		}
+		return seq.setUnlock(out, f(latestA, latestB))
+	})
+	subscribe(out, b, func(event Event[B]) error {
+		seq.mu.Lock()
+		latestB, knownB = event.Payload, true
+		if !knownA {
+			seq.mu.Unlock()
+			return nil
+		}
+		return seq.setUnlock(out, f(latestA, latestB))
+	})
+	return out
+}
+
+// DistinctUntilChanged 忽略与上一个状态相同的状态
+func DistinctUntilChanged[E comparable](source Subject[E]) *Stream[E] {
+	var seq sequencer[E]
+	last, known := stateOf(source)
+	out := newStream(last)
+	out.unset.Store(!known)
+	subscribe(out, source, func(event Event[E]) error {
+		seq.mu.Lock()
+		if known && event.Payload == last {
+			seq.mu.Unlock()
+			return nil
+		}
+		last, known = event.Payload, true
+		return seq.setUnlock(out, last)
+	})
+	return out
+}
+
+// 有状态的运算符在 mu 中计算新状态, 再按计算的顺序设置 Stream 的状态。
+// 同 pending.emitUnlock, 同一时刻只有一个 goroutine 调用 SetState, 其他 goroutine 只排队,
+// 因此不持有 mu 通知下游, 下游反过来修改来源时也不会死锁
+type sequencer[R any] struct {
+	mu       sync.Mutex
+	queue    []R
+	draining bool
+}
+
+// 调用时持有 q.mu, 返回时释放。返回本次调用设置的所有状态的错误
+func (q *sequencer[R]) setUnlock(out *Stream[R], state R) error {
+	q.queue = append(q.queue, state)
+	if q.draining {
+		q.mu.Unlock()
+		return nil
+	}
+	q.draining = true
+	var errs []error
+	for len(q.queue) > 0 {
+		next := q.queue[0]
+		q.queue = q.queue[1:]
+		q.mu.Unlock()
+		if err := out.SetState(next); err != nil {
+			errs = append(errs, err)
+		}
+		q.mu.Lock()
+	}
+	q.draining = false
+	q.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Buffer 每 n 个状态变化通知一次这 n 个状态
+func Buffer[E any](source Subject[E], n int) *Stream[[]E] {
+	return batched(source, Batch[E](n, 0, RealClock))
+}
+
+// Window 把状态变化按时间分组, 第一个变化之后经过 d 通知这段时间内的所有状态, 没有变化的时间段不通知
+func Window[E any](source Subject[E], d time.Duration, clock Clock) *Stream[[]E] {
+	return batched(source, Batch[E](math.MaxInt, d, clock))
+}
+
+func batched[E any](source Subject[E], policy NotificationPolicy[E]) *Stream[[]E] {
+	out := newStream[[]E](nil)
+	emit := func(events []Event[E]) {
+		states := make([]E, len(events))
+		for i, event := range events {
+			states[i] = event.Payload
+		}
+		out.SetState(states)
+	}
+	subscribe(out, source, func(event Event[E]) error {
+		policy.Submit(event, emit)
+		return nil
+	})
+	out.onClose(func() { policy.Flush(emit) })
+	return out
+}
+
+// ToChannel 把来源的状态变化发送到通道, ctx 取消后注销并关闭通道, 来源是 Stream 时同 Stream 的关闭规则。
+// 通道满时通知会等待读取, 因此会拖慢来源的同步通知
+func ToChannel[E any](ctx context.Context, source Subject[E], buffer int) <-chan E {
+	ch := make(chan E, buffer)
+	var mu sync.Mutex // 发送和关闭互斥
+	closed := false
+	o := &operator[E]{update: func(event Event[E]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return nil
+		}
+		select {
+		case ch <- event.Payload:
+		case <-ctx.Done():
+		}
+		return nil
+	}}
+	source.Register(o)
+	context.AfterFunc(ctx, func() {
+		source.Deregister(o)
+		release(source)
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	})
+	return ch
+}
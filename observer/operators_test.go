@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 在限定时间内运行 f, 超时说明运算符死锁
+func withinSecond(t *testing.T, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("deadlock: operator did not return")
+	}
+}
+
+// 记录主题收到的所有状态
+func record[E any](source Subject[E]) *[]E {
+	var states []E
+	source.Register(ObserverFunc[E](func(event Event[E]) error {
+		states = append(states, event.Payload)
+		return nil
+	}))
+	return &states
+}
+
+// 下游反过来修改来源时, 有状态的运算符不会死锁, 并且按计算的顺序通知
+func TestOperatorsFeedback(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// 返回要观察的 Stream, 它的观察者在状态小于 3 时把来源设置为下一个值
+		build func(source *ConcreteSubject[int]) *Stream[int]
+		want  string // 为空时只检查最终状态, 菱形依赖的中间状态取决于注册顺序
+	}{
+		{"scan", func(s *ConcreteSubject[int]) *Stream[int] {
+			return Scan(s, 0, func(acc, v int) int { return v })
+		}, "[1 2 3]"},
+		{"distinct", func(s *ConcreteSubject[int]) *Stream[int] {
+			return DistinctUntilChanged[int](s)
+		}, "[1 2 3]"},
+		{"combine with derived source", func(s *ConcreteSubject[int]) *Stream[int] {
+			return CombineLatest(s, Map(s, func(v int) int { return v * 10 }), func(a, b int) int { return b / 10 })
+		}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewConcreteSubject(0, ContinueOnError)
+			out := test.build(source)
+			var states []int
+			out.Register(ObserverFunc[int](func(event Event[int]) error {
+				states = append(states, event.Payload)
+				if event.Payload < 3 {
+					source.SetState(event.Payload + 1)
+				}
+				return nil
+			}))
+			withinSecond(t, func() { source.SetState(1) })
+			if test.want != "" && fmt.Sprint(states) != test.want {
+				t.Errorf("states = %v, want %s", states, test.want)
+			}
+			if out.GetState() != 3 || source.GetState() != 3 {
+				t.Errorf("final state = %d, source = %d; want 3", out.GetState(), source.GetState())
+			}
+		})
+	}
+}
+
+// Filter 不把来源中不满足条件的当前状态交给下游
+func TestFilterStartsUnset(t *testing.T) {
+	odd := func(v int) bool { return v%2 == 1 }
+	source := NewConcreteSubject(2, ContinueOnError)
+	filtered := Filter[int](source, odd)
+	if _, ok := filtered.current(); ok {
+		t.Error("filter has a state before any state passed")
+	}
+	doubled := Map(filtered, func(v int) int { return v * 2 })
+	if _, ok := doubled.current(); ok {
+		t.Error("map of an unset filter has a state")
+	}
+	other := NewConcreteSubject("x", ContinueOnError)
+	combined := CombineLatest(filtered, other, func(v int, s string) string { return fmt.Sprint(s, v) })
+	states := record[string](combined)
+
+	other.SetState("y") // filter 还没有状态, 不通知
+	source.SetState(4)
+	source.SetState(5)
+	if fmt.Sprint(*states) != "[y5]" {
+		t.Errorf("combined = %v, want [y5]", *states)
+	}
+	if state, ok := doubled.current(); !ok || state != 10 {
+		t.Errorf("doubled = %v, %v; want 10, true", state, ok)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// NotificationPolicy 通知策略, 决定状态变化何时、以什么形式通知观察者。
+// 设置了策略的主题在 SetState 中只把事件交给策略, 观察者的错误交给 ErrorHandler
+type NotificationPolicy[E any] interface {
+	// Submit 收到一次状态变化, 策略在合适的时候调用 emit 通知观察者, 可以合并或推迟事件
+	Submit(event Event[E], emit func(events []Event[E]))
+	// Flush 立即发出所有等待中的事件并取消计时器
+	Flush(emit func(events []Event[E]))
+}
+
+// BatchObserver 可以一次处理多个事件的观察者。
+// 策略一次发出多个事件时, 实现了它的观察者收到整批事件, 其他观察者逐个收到
+type BatchObserver[E any] interface {
+	Observer[E]
+	UpdateBatch(events []Event[E]) error
+}
+
+// 等待发出的事件和计时器, 各个策略共用。
+// gen 在计时器作废时递增, 已经开始执行的过期回调据此放弃
+type pending[E any] struct {
+	mu     sync.Mutex
+	clock  Clock
+	events []Event[E]
+	timer  Timer
+	gen    int
+
+	// 已经决定发出、还没有发出的事件, 按决定的顺序发出
+	outbox   []emission[E]
+	draining bool
+}
+
+type emission[E any] struct {
+	events []Event[E]
+	emit   func([]Event[E])
+}
+
+func newPending[E any](clock Clock) pending[E] {
+	if clock == nil {
+		clock = RealClock
+	}
+	return pending[E]{clock: clock}
+}
+
+// 调用时持有 p.mu, 返回时释放。
+// 同一时刻只有一个 goroutine 发出事件, 其他 goroutine 只把事件排进 outbox,
+// 因此观察者收到事件的顺序和策略做出决定的顺序一致, 观察者在 Update 中 SetState 也不会死锁
+func (p *pending[E]) emitUnlock(events []Event[E], emit func([]Event[E])) {
+	if len(events) > 0 {
+		p.outbox = append(p.outbox, emission[E]{events: events, emit: emit})
+	}
+	if p.draining {
+		p.mu.Unlock()
+		return
+	}
+	p.draining = true
+	for len(p.outbox) > 0 {
+		next := p.outbox[0]
+		p.outbox = p.outbox[1:]
+		p.mu.Unlock()
+		next.emit(next.events)
+		p.mu.Lock()
+	}
+	p.draining = false
+	p.mu.Unlock()
+}
+
+// 设置计时器, 调用时持有 p.mu。fire 在持有 p.mu 时被调用, 负责释放
+func (p *pending[E]) schedule(d time.Duration, fire func()) {
+	p.cancel()
+	gen := p.gen
+	p.timer = p.clock.AfterFunc(d, func() {
+		p.mu.Lock()
+		if gen != p.gen {
+			p.mu.Unlock()
+			return
+		}
+		p.timer = nil
+		fire()
+	})
+}
+
+// 取消计时器, 调用时持有 p.mu
+func (p *pending[E]) cancel() {
+	p.gen++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// 取出等待中的事件, 调用时持有 p.mu
+func (p *pending[E]) take() []Event[E] {
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// 调用时持有 p.mu, 返回时释放
+func (p *pending[E]) flushUnlock(emit func([]Event[E])) {
+	p.cancel()
+	p.emitUnlock(p.take(), emit)
+}
+
+func (p *pending[E]) flush(emit func([]Event[E])) {
+	p.mu.Lock()
+	p.flushUnlock(emit)
+}
+
+// 只保留最新的事件
+func (p *pending[E]) keepLatest(event Event[E]) {
+	p.events = append(p.events[:0], event)
+}
+
+type debounce[E any] struct {
+	pending[E]
+	quiet time.Duration
+}
+
+// Debounce 状态停止变化 quiet 之后才通知最新的状态, clock 为 nil 时使用 RealClock
+func Debounce[E any](quiet time.Duration, clock Clock) NotificationPolicy[E] {
+	return &debounce[E]{pending: newPending[E](clock), quiet: quiet}
+}
+
+func (d *debounce[E]) Submit(event Event[E], emit func([]Event[E])) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keepLatest(event)
+	d.schedule(d.quiet, func() { d.flushUnlock(emit) })
+}
+
+func (d *debounce[E]) Flush(emit func([]Event[E])) {
+	d.flush(emit)
+}
+
+type throttle[E any] struct {
+	pending[E]
+	limit    int
+	interval time.Duration
+	start    time.Time // 当前时间窗口的开始时间
+	count    int       // 当前时间窗口内已经通知的次数
+}
+
+// Throttle 每个 interval 最多通知 limit 次, clock 为 nil 时使用 RealClock。
+// 超出的状态变化只保留最新的一个, 在下一个时间窗口开始时通知, 因此最终状态不会丢失
+func Throttle[E any](limit int, interval time.Duration, clock Clock) NotificationPolicy[E] {
+	return &throttle[E]{pending: newPending[E](clock), limit: max(limit, 1), interval: interval}
+}
+
+func (t *throttle[E]) Submit(event Event[E], emit func([]Event[E])) {
+	t.mu.Lock()
+	now := t.clock.Now()
+	if !now.Before(t.start.Add(t.interval)) {
+		t.start, t.count = now, 0
+	}
+	if t.count < t.limit && len(t.events) == 0 {
+		t.count++
+		t.emitUnlock([]Event[E]{event}, emit)
+		return
+	}
+	t.keepLatest(event)
+	if t.timer == nil {
+		t.schedule(t.start.Add(t.interval).Sub(now), func() {
+			t.start, t.count = t.clock.Now(), 1
+			t.emitUnlock(t.take(), emit)
+		})
+	}
+	t.mu.Unlock()
+}
+
+func (t *throttle[E]) Flush(emit func([]Event[E])) {
+	t.flush(emit)
+}
+
+type coalesce[E any] struct {
+	pending[E]
+	delay time.Duration
+}
+
+// Coalesce 第一次状态变化 delay 之后通知, 期间的多次变化合并为最新的状态, clock 为 nil 时使用 RealClock。
+// 与 Debounce 不同, 持续变化的状态也会按固定的间隔通知
+func Coalesce[E any](delay time.Duration, clock Clock) NotificationPolicy[E] {
+	return &coalesce[E]{pending: newPending[E](clock), delay: delay}
+}
+
+func (c *coalesce[E]) Submit(event Event[E], emit func([]Event[E])) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keepLatest(event)
+	if c.timer == nil {
+		c.schedule(c.delay, func() { c.flushUnlock(emit) })
+	}
+}
+
+func (c *coalesce[E]) Flush(emit func([]Event[E])) {
+	c.flush(emit)
+}
+
+type batch[E any] struct {
+	pending[E]
+	size    int
+	maxWait time.Duration
+}
+
+// Batch 攒够 size 个状态变化, 或者第一个变化之后等待了 maxWait, 就一次发出这一批, clock 为 nil 时使用 RealClock
+func Batch[E any](size int, maxWait time.Duration, clock Clock) NotificationPolicy[E] {
+	return &batch[E]{pending: newPending[E](clock), size: max(size, 1), maxWait: maxWait}
+}
+
+func (b *batch[E]) Submit(event Event[E], emit func([]Event[E])) {
+	b.mu.Lock()
+	b.events = append(b.events, event)
+	if len(b.events) >= b.size {
+		b.flushUnlock(emit)
+		return
+	}
+	if len(b.events) == 1 && b.maxWait > 0 {
+		b.schedule(b.maxWait, func() { b.flushUnlock(emit) })
+	}
+	b.mu.Unlock()
+}
+
+func (b *batch[E]) Flush(emit func([]Event[E])) {
+	b.flush(emit)
+}
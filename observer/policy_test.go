@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试步骤: 先把时钟推进 advance, 再依次提交 submit 中的状态
+type step struct {
+	advance time.Duration
+	submit  []int
+}
+
+// 按步骤驱动策略, 返回每次发出的一批状态, 如 "[1] [2 3]"
+func drive(policy func(Clock) NotificationPolicy[int], steps []step) string {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := policy(clock)
+	var emitted []string
+	emit := func(events []Event[int]) {
+		payloads := make([]int, len(events))
+		for i, event := range events {
+			payloads[i] = event.Payload
+		}
+		emitted = append(emitted, fmt.Sprint(payloads))
+	}
+	for _, s := range steps {
+		clock.Advance(s.advance)
+		for _, v := range s.submit {
+			p.Submit(Event[int]{Payload: v}, emit)
+		}
+	}
+	return strings.Join(emitted, " ")
+}
+
+func TestNotificationPolicies(t *testing.T) {
+	ms := time.Millisecond
+	for _, test := range []struct {
+		name   string
+		policy func(Clock) NotificationPolicy[int]
+		steps  []step
+		want   string
+	}{
+		{
+			"debounce waits for quiet",
+			func(c Clock) NotificationPolicy[int] { return Debounce[int](10*ms, c) },
+			[]step{{0, []int{1}}, {5 * ms, []int{2}}, {9 * ms, []int{3}}, {10 * ms, nil}},
+			"[3]",
+		},
+		{
+			"throttle defers the latest excess state to the next window",
+			func(c Clock) NotificationPolicy[int] { return Throttle[int](2, 10*ms, c) },
+			[]step{{0, []int{1, 2, 3, 4}}, {10 * ms, nil}, {3 * ms, []int{5}}, {7 * ms, nil}, {10 * ms, []int{6}}},
+			"[1] [2] [4] [5] [6]",
+		},
+		{
+			"throttle starts a new window after idle time",
+			func(c Clock) NotificationPolicy[int] { return Throttle[int](1, 10*ms, c) },
+			[]step{{0, []int{1}}, {25 * ms, []int{2}}, {ms, []int{3}}, {9 * ms, nil}},
+			"[1] [2] [3]",
+		},
+		{
+			"coalesce emits at a fixed delay while changing",
+			func(c Clock) NotificationPolicy[int] { return Coalesce[int](10*ms, c) },
+			[]step{{0, []int{1}}, {5 * ms, []int{2}}, {5 * ms, []int{3}}, {5 * ms, []int{4}}, {5 * ms, nil}},
+			"[2] [4]",
+		},
+		{
+			"window groups states by time",
+			func(c Clock) NotificationPolicy[int] { return Batch[int](100, 10*ms, c) },
+			[]step{{0, []int{1, 2}}, {9 * ms, []int{3}}, {ms, nil}, {50 * ms, nil}, {0, []int{4}}, {10 * ms, nil}},
+			"[1 2 3] [4]",
+		},
+		{
+			"batch emits when full before the wait ends",
+			func(c Clock) NotificationPolicy[int] { return Batch[int](2, 10*ms, c) },
+			[]step{{0, []int{1, 2, 3}}, {10 * ms, nil}},
+			"[1 2] [3]",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := drive(test.policy, test.steps); got != test.want {
+				t.Errorf("emitted %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Flush 立即发出等待中的事件, 之后到期的计时器不再发出
+func TestNotificationPolicyFlush(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	policy := Coalesce[int](10*time.Millisecond, clock)
+	var emitted []int
+	emit := func(events []Event[int]) {
+		for _, event := range events {
+			emitted = append(emitted, event.Payload)
+		}
+	}
+	policy.Submit(Event[int]{Payload: 1}, emit)
+	policy.Flush(emit)
+	clock.Advance(time.Second)
+	if fmt.Sprint(emitted) != "[1]" {
+		t.Errorf("emitted %v, want [1]", emitted)
+	}
+}
+
+// 观察者在 Update 中修改同一个主题的状态, 通知按策略决定的顺序进行且不会死锁
+func TestNotificationPolicyReentrant(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	subject := NewConcreteSubject(0, ContinueOnError)
+	subject.SetNotificationPolicy(Throttle[int](10, time.Second, clock))
+	var received []int
+	subject.Register(ObserverFunc[int](func(event Event[int]) error {
+		received = append(received, event.Payload)
+		if event.Payload < 3 {
+			subject.SetState(event.Payload + 1)
+		}
+		return nil
+	}))
+	subject.SetState(1)
+	if fmt.Sprint(received) != "[1 2 3]" {
+		t.Errorf("received %v, want [1 2 3]", received)
+	}
+}
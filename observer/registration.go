@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"weak"
+)
+
+// RegisterOptions 注册观察者的选项, 零值表示普通注册
+type RegisterOptions[E any] struct {
+	// Context 取消时自动注销
+	Context context.Context
+	// Once 为 true 时第一次通知后自动注销
+	Once bool
+	// When 不为 nil 时只在新状态满足条件时通知
+	When func(state E) bool
+}
+
+// Registration 带选项的注册, 注册到主题中的是它本身
+type Registration[E any] struct {
+	subject *ConcreteSubject[E]
+	target  func() Observer[E] // 弱引用的观察者被回收后返回 nil
+	name    string
+	options RegisterOptions[E]
+	done    atomic.Bool
+
+	mu   sync.Mutex
+	stop func() bool // 停止监听 Context, Context 可能在赋值之前就被取消
+}
+
+// RegisterWith 按选项注册观察者, 返回的 Registration 可以用来提前注销
+func (s *ConcreteSubject[E]) RegisterWith(observer Observer[E], options RegisterOptions[E]) *Registration[E] {
+	r := &Registration[E]{
+		subject: s,
+		target:  func() Observer[E] { return observer },
+		name:    fmt.Sprint(observer),
+		options: options,
+	}
+	return r.register()
+}
+
+// RegisterWeak 以弱引用注册观察者, 主题不会阻止观察者被垃圾回收, 回收后自动注销
+func RegisterWeak[E, T any, P interface {
+	*T
+	Observer[E]
+}](s *ConcreteSubject[E], observer P, options RegisterOptions[E]) *Registration[E] {
+	pointer := weak.Make((*T)(observer))
+	r := &Registration[E]{
+		subject: s,
+		target: func() Observer[E] {
+			if p := pointer.Value(); p != nil {
+				return P(p)
+			}
+			return nil
+		},
+		name:    fmt.Sprint(observer),
+		options: options,
+	}
+	// 清理函数只引用 r, r 只持有弱引用, 不会让观察者一直存活
+	runtime.AddCleanup((*T)(observer), func(r *Registration[E]) { r.Unregister() }, r)
+	return r.register()
+}
+
+func (r *Registration[E]) register() *Registration[E] {
+	if ctx := r.options.Context; ctx != nil {
+		if ctx.Err() != nil {
+			r.done.Store(true)
+			return r
+		}
+		r.mu.Lock()
+		r.stop = context.AfterFunc(ctx, r.Unregister)
+		r.mu.Unlock()
+	}
+	r.subject.Register(r)
+	if r.done.Load() {
+		// Context 在注册完成前被取消, Unregister 当时还找不到它
+		r.subject.Deregister(r)
+	}
+	return r
+}
+
+func (r *Registration[E]) Update(event Event[E]) error {
+	if r.done.Load() {
+		return nil
+	}
+	// AfterFunc 在另一个 goroutine 中执行, Context 取消后的通知在这里拦下
+	if ctx := r.options.Context; ctx != nil && ctx.Err() != nil {
+		r.Unregister()
+		return nil
+	}
+	if r.options.When != nil && !r.options.When(event.Payload) {
+		return nil
+	}
+	observer := r.target()
+	if observer == nil {
+		r.Unregister()
+		return nil
+	}
+	if r.options.Once {
+		// 异步或并发通知时也只调用一次
+		if !r.done.CompareAndSwap(false, true) {
+			return nil
+		}
+		r.remove()
+	}
+	return observer.Update(event)
+}
+
+// Unregister 注销, 可以重复调用
+func (r *Registration[E]) Unregister() {
+	if r.done.CompareAndSwap(false, true) {
+		r.remove()
+	}
+}
+
+func (r *Registration[E]) remove() {
+	r.mu.Lock()
+	if r.stop != nil {
+		r.stop()
+	}
+	r.mu.Unlock()
+	r.subject.Deregister(r)
+}
+
+// Active 是否仍然注册在主题上
+func (r *Registration[E]) Active() bool {
+	return !r.done.Load()
+}
+
+func (r *Registration[E]) String() string {
+	return r.name
+}